@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdAudit struct {
+	FormatOptions
+	Since   string   `long:"since" description:"Show records since timestamp (RFC3339) or duration ago"`
+	Until   string   `long:"until" description:"Show records until timestamp (RFC3339) or duration ago"`
+	Methods []string `short:"m" long:"method" description:"Show records of the method only (e.g. Exec, Docker.RemoveContainer)"`
+	Users   []string `short:"u" long:"user" description:"Show records of the user claimed by the client only (e.g. alice@host)"`
+	Match   string   `long:"match" description:"Show records whose arguments contain the string"`
+	Tail    int      `short:"n" long:"tail" description:"Show the last N records only"`
+	Full    bool     `long:"full" description:"Show full arguments"`
+}
+
+func (opts *CmdAudit) Execute(args []string) error {
+	req := rpc.AuditRequest{
+		Methods: opts.Methods,
+		Users:   opts.Users,
+		Match:   opts.Match,
+		Limit:   opts.Tail,
+	}
+	var err error
+	if req.Since, err = parseTime(opts.Since, -1); err != nil {
+		log.WithField("error", err).Fatal("Invalid since value")
+	}
+	if req.Until, err = parseTime(opts.Until, -1); err != nil {
+		log.WithField("error", err).Fatal("Invalid until value")
+	}
+	records, err := rpc.Audit(gopts.agents(), req)
+	logRPCError(err)
+	if opts.Formatted() {
+		return opts.WriteRecords(os.Stdout, records)
+	}
+
+	var tw tableWriter
+	tw.Append("TIME", "AGENT", "CLAIMED USER", "CALLER", "METHOD", "DURATION", "RESULT", "ARGS")
+	for _, r := range records {
+		result := "ok"
+		if r.Error != "" {
+			result = r.Error
+		}
+		args := string(r.Args)
+		if len(args) > 40 && !opts.Full {
+			args = args[:40]
+		}
+		tw.Append(r.Time.Local().Format("2006-01-02 15:04:05"), r.Agent, r.ClaimedUser, r.Caller,
+			r.Method, (r.Duration - r.Duration%time.Millisecond).String(), result, args)
+	}
+	tw.Write(os.Stdout, "")
+	return nil
+}
+
+func init() {
+	parser.AddCommand("audit", "Show audit records of mutating operations across agents", "", &CmdAudit{})
+}
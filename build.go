@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdBuild struct {
+	Tag        string `short:"t" long:"tag" description:"Repository name and tag for the image" required:"yes"`
+	Dockerfile string `short:"f" long:"file" description:"Name of the Dockerfile in the context"`
+	NoCache    bool   `long:"no-cache" description:"Do not use cache when building the image"`
+	Pull       bool   `long:"pull" description:"Always attempt to pull a newer version of the image"`
+	Distribute bool   `long:"distribute" description:"Load the built image to the rest of target agents"`
+	Compress   bool   `long:"compress" description:"Compress stream using LZ4"`
+	Args       struct {
+		Path string `positional-arg-name:"PATH"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (opts *CmdBuild) Execute(args []string) error {
+	caps := gatherCapabilities(gopts.agents())
+	agent := leastLoadedAgent(caps)
+	if agent == "" {
+		log.WithField("error", "No available agents").Fatal("Could not find an agent to build")
+	}
+
+	req := rpc.BuildImageRequest{
+		Name:       opts.Tag,
+		Dockerfile: opts.Dockerfile,
+		NoCache:    opts.NoCache,
+		Pull:       opts.Pull,
+		Compress:   opts.Compress,
+	}
+	if opts.Distribute {
+		delete(caps, agent)
+		req.Rest = caps.Agents()
+		sort.Strings(req.Rest)
+	}
+
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, opts.Args.Path, dockerfile))
+	}()
+	log.WithFields(log.Fields{"agent": agent, "image": opts.Tag}).Info("Building image")
+	logRPCError(rpc.BuildImage(agent, pr, req))
+	return nil
+}
+
+// writeTar writes the contents of dir into w as a tar archive. Files matching
+// .dockerignore are left out as docker build does, except the Dockerfile and
+// .dockerignore itself.
+func writeTar(w io.Writer, dir, dockerfile string) error {
+	patterns, err := readDockerIgnore(dir)
+	if err != nil {
+		return err
+	}
+	var exceptions bool
+	for _, p := range patterns {
+		exceptions = exceptions || p.exclusion
+	}
+	keep := map[string]bool{filepath.Clean(dockerfile): true, ".dockerignore": true}
+
+	tw := tar.NewWriter(w)
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		if !keep[name] && ignored(patterns, name) {
+			// files in the directory may be included again by exceptions
+			if fi.IsDir() && !exceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ignorePattern is a line of .dockerignore.
+type ignorePattern struct {
+	re        *regexp.Regexp
+	dirs      int  // number of path elements
+	exclusion bool // starting with "!" to include files again
+}
+
+func readDockerIgnore(dir string) ([]*ignorePattern, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []*ignorePattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.exclusion = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = filepath.ToSlash(filepath.Clean(line))
+		if len(line) > 1 && line[0] == '/' {
+			line = line[1:]
+		}
+		if p.re, err = ignoreRegexp(line); err != nil {
+			return nil, fmt.Errorf("Invalid .dockerignore pattern: %s", line)
+		}
+		p.dirs = len(strings.Split(line, "/"))
+		patterns = append(patterns, &p)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignoreRegexp converts a pattern into a regexp. It's filepath.Match
+// extended by "**" matching any number of directories.
+func ignoreRegexp(pattern string) (*regexp.Regexp, error) {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+				}
+				if i+1 == len(pattern) {
+					b.WriteString(".*")
+				} else {
+					b.WriteString("(.*/)?")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			// character classes are written in the same way as regexp
+			n := strings.IndexByte(pattern[i:], ']')
+			if n < 0 {
+				return nil, filepath.ErrBadPattern
+			}
+			b.WriteString(pattern[i : i+n+1])
+			i += n
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// ignored reports whether the file at the relative path is excluded. The
+// last matching pattern wins, and a pattern matching a parent directory
+// matches the files in it.
+func ignored(patterns []*ignorePattern, name string) bool {
+	name = filepath.ToSlash(name)
+	parents := strings.Split(path.Dir(name), "/")
+	var matched bool
+	for _, p := range patterns {
+		match := p.re.MatchString(name)
+		if !match && parents[0] != "." && p.dirs <= len(parents) {
+			match = p.re.MatchString(strings.Join(parents[:p.dirs], "/"))
+		}
+		if match {
+			matched = !p.exclusion
+		}
+	}
+	return matched
+}
+
+func init() {
+	parser.AddCommand("build", "Build an image on an agent", "", &CmdBuild{})
+}
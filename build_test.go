@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]*tar.Header {
+	out := make(map[string]*tar.Header)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return out
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		out[hdr.Name] = hdr
+	}
+}
+
+func TestWriteTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "craft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile":       "FROM scratch",
+		".dockerignore":    "# comment\n*.log\n!keep.log\n/tmp\n**/*.bak\nDockerfile\n.dockerignore\n",
+		"app/main.go":      "package main",
+		"app/main.go.bak":  "",
+		"app/lib/util.bak": "",
+		"debug.log":        "",
+		"keep.log":         "",
+		"tmp/cache":        "",
+		"tmpfile":          "",
+	})
+	if err = os.Mkdir(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("app/main.go", filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err = writeTar(&buf, dir, "Dockerfile"); err != nil {
+		t.Fatal(err)
+	}
+	hdrs := readTar(t, &buf)
+	var names []string
+	for name := range hdrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expected := []string{
+		".dockerignore", "Dockerfile", "app/", "app/lib/", "app/main.go",
+		"data/", "keep.log", "main.go", "tmpfile",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, but %v", expected, names)
+	}
+	if hdr := hdrs["main.go"]; hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "app/main.go" {
+		t.Errorf("unexpected symlink: %+v", hdr)
+	}
+	if hdr := hdrs["data/"]; hdr.Typeflag != tar.TypeDir {
+		t.Errorf("unexpected directory: %+v", hdr)
+	}
+	if hdr := hdrs["app/main.go"]; hdr.Size != int64(len("package main")) {
+		t.Errorf("unexpected file: %+v", hdr)
+	}
+}
+
+func TestIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "craft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{".dockerignore": "docs\n!docs/README.md\n*/temp?\nbuild/**\n"})
+	patterns, err := readDockerIgnore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []struct {
+		name     string
+		expected bool
+	}{
+		{"docs", true},
+		{"docs/guide.md", true},
+		{"docs/README.md", false},
+		{"src/temp1", true},
+		{"src/temp1/file", true},
+		{"src/a/temp1", false},
+		{"build", false},
+		{"build/out/bin", true},
+		{"README.md", false},
+	}
+	for _, test := range data {
+		if v := ignored(patterns, test.name); v != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, v, test.expected)
+		}
+	}
+}
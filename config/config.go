@@ -2,16 +2,116 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	Path      string `json:"-"`
 	Listen    string
 	Docker    string
 	AgentName string `json:"agent_name"`
 	Labels    map[string]string
 	Agents    []string
+	Inventory Inventory
+	Discovery string
+	Announce  bool
+	Seeds     []string
+	HTTP      string
+	// HTTPToken is required by the HTTP API if set. Otherwise anyone who
+	// can reach HTTP can operate the agent, as with Listen.
+	HTTPToken string `json:"http_token"`
+	DNS       string
+	Network   NetworkConfig
+	Audit     AuditConfig
+	SecretKey string `json:"secret_key"`
+	Secrets   string
+	Devices   []string
+	Probes    []Probe
+	// ShutdownTimeout is how long the agent waits for operations in flight
+	// on SIGTERM.
+	ShutdownTimeout string `json:"shutdown_timeout"`
+}
+
+// ShutdownWait returns ShutdownTimeout, which is 5 minutes by default.
+func (c *Config) ShutdownWait() time.Duration {
+	d, err := time.ParseDuration(c.ShutdownTimeout)
+	if err != nil || d <= 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// Probe is a script whose output lines of KEY=VALUE are added to labels of
+// the agent.
+type Probe struct {
+	Command  string
+	Interval string
+}
+
+// Duration returns the interval to run the probe, which is 1 minute by
+// default.
+func (p *Probe) Duration() time.Duration {
+	d, err := time.ParseDuration(p.Interval)
+	if err != nil || d <= 0 {
+		return time.Minute
+	}
+	return d
+}
+
+// NetworkConfig selects addresses of the agent reported to clients. Include
+// and Exclude are interface names, which may contain wildcards, or CIDRs.
+// docker0 is always excluded in addition to Exclude, since its address isn't
+// reachable from other hosts. Advertise is reported as the first address.
+// Networks names CIDRs so that exlinks can choose addresses by the name.
+type NetworkConfig struct {
+	Include   []string
+	Exclude   []string
+	Advertise string
+	Networks  map[string]string
+}
+
+func (n *NetworkConfig) Validate() error {
+	for _, s := range append(n.Include, n.Exclude...) {
+		if strings.Contains(s, "/") {
+			if _, _, err := net.ParseCIDR(s); err != nil {
+				return fmt.Errorf("Invalid network: %s", s)
+			}
+		}
+	}
+	if n.Advertise != "" && net.ParseIP(n.Advertise) == nil {
+		return fmt.Errorf("Invalid advertise address: %s", n.Advertise)
+	}
+	for name, s := range n.Networks {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return fmt.Errorf("Invalid network %s: %s", name, s)
+		}
+	}
+	return nil
+}
+
+const defaultExclude = "docker0"
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditConfig configures the audit log of the agent. MaxSize is in
+// megabytes.
+type AuditConfig struct {
+	Path     string
+	MaxSize  int64 `json:"max_size"`
+	MaxFiles int   `json:"max_files"`
 }
 
 func Parse(path string) (*Config, error) {
@@ -25,15 +125,62 @@ func Parse(path string) (*Config, error) {
 			return nil, err
 		}
 	}
+	c.Path = path
 	if c.Listen == "" {
 		c.Listen = ":7300"
 	}
 	if c.Docker == "" {
 		c.Docker = "unix:///var/run/docker.sock"
 	}
+	if c.Discovery == "" {
+		c.Discovery = "239.255.73.1:7301"
+	}
+	if c.Audit.Path == "" {
+		c.Audit.Path = "/var/log/craft/audit.log"
+	}
+	if c.Audit.MaxSize == 0 {
+		c.Audit.MaxSize = 100
+	}
+	if c.Audit.MaxFiles == 0 {
+		c.Audit.MaxFiles = 5
+	}
+	if c.SecretKey == "" {
+		c.SecretKey = "/etc/craft/secret.key"
+	}
+	if c.Secrets == "" {
+		c.Secrets = "/var/lib/craft/secrets.json"
+	}
+	if !contains(c.Network.Exclude, defaultExclude) {
+		c.Network.Exclude = append(c.Network.Exclude, defaultExclude)
+	}
+	if err := c.Network.Validate(); err != nil {
+		return nil, err
+	}
+	if c.Devices == nil {
+		c.Devices = []string{"/dev/kvm", "/dev/fuse", "/dev/nvidia0"}
+	}
+	for _, p := range c.Probes {
+		if p.Command == "" {
+			return nil, errors.New("Probe command required")
+		}
+		if _, err := time.ParseDuration(p.Interval); p.Interval != "" && err != nil {
+			return nil, fmt.Errorf("Invalid probe interval: %s", p.Interval)
+		}
+	}
+	if _, err := time.ParseDuration(c.ShutdownTimeout); c.ShutdownTimeout != "" && err != nil {
+		return nil, fmt.Errorf("Invalid shutdown timeout: %s", c.ShutdownTimeout)
+	}
 	if c.AgentName == "" {
 		c.AgentName, _ = os.Hostname()
 	}
+	if err := c.Inventory.Validate(); err != nil {
+		return nil, err
+	}
+	agents, err := c.Inventory.Expand(append(c.Agents, c.Inventory.AllAgents()...))
+	if err != nil {
+		return nil, err
+	}
+	c.Agents = agents
 	if len(c.Agents) == 0 {
 		c.Agents = append(c.Agents, "localhost:7300")
 	}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func parseConfig(t *testing.T, s string) *Config {
+	f, err := ioutil.TempFile("", "craft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(s)
+	f.Close()
+	c, err := Parse(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseNetworkExclude(t *testing.T) {
+	data := []struct {
+		config   string
+		expected []string
+	}{
+		{`{}`, []string{"docker0"}},
+		{`{"network": {"exclude": []}}`, []string{"docker0"}},
+		{`{"network": {"exclude": ["veth*"]}}`, []string{"veth*", "docker0"}},
+		{`{"network": {"exclude": ["docker0", "veth*"]}}`, []string{"docker0", "veth*"}},
+	}
+	for i, test := range data {
+		c := parseConfig(t, test.config)
+		if !reflect.DeepEqual(c.Network.Exclude, test.expected) {
+			t.Errorf("index %d failed: got %v, expected %v", i, c.Network.Exclude, test.expected)
+		}
+	}
+}
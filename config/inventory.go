@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Inventory organizes agents into named groups. A group may contain other
+// groups. Labels of agents are known to the client even if the agents are
+// offline.
+type Inventory struct {
+	Groups map[string]Group
+	Agents map[string]AgentInfo
+}
+
+type Group struct {
+	Agents []string
+	Groups []string
+}
+
+type AgentInfo struct {
+	Labels map[string]string
+}
+
+// Resolve returns agents which belong to the group including its nested
+// groups.
+func (inv *Inventory) Resolve(name string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	err := inv.walk(name, nil, func(g *Group) {
+		for _, agent := range g.Agents {
+			if !seen[agent] {
+				seen[agent] = true
+				out = append(out, agent)
+			}
+		}
+	})
+	return out, err
+}
+
+func (inv *Inventory) walk(name string, path []string, f func(*Group)) error {
+	for _, p := range path {
+		if p == name {
+			return fmt.Errorf("Circular group reference: %s", strings.Join(append(path, name), " -> "))
+		}
+	}
+	g, ok := inv.Groups[name]
+	if !ok {
+		return fmt.Errorf("Unknown group: %s", name)
+	}
+	f(&g)
+	path = append(path, name)
+	for _, child := range g.Groups {
+		if err := inv.walk(child, path, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expand replaces group references in the form of @name with its agents.
+func (inv *Inventory) Expand(agents []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, agent := range agents {
+		v := []string{agent}
+		if strings.HasPrefix(agent, "@") {
+			var err error
+			if v, err = inv.Resolve(agent[1:]); err != nil {
+				return nil, err
+			}
+		}
+		for _, agent := range v {
+			if !seen[agent] {
+				seen[agent] = true
+				out = append(out, agent)
+			}
+		}
+	}
+	return out, nil
+}
+
+// GroupsOf returns sorted names of groups the agent belongs to directly or
+// through nested groups.
+func (inv *Inventory) GroupsOf(agent string) []string {
+	var out []string
+	for name := range inv.Groups {
+		agents, _ := inv.Resolve(name)
+		for _, a := range agents {
+			if a == agent {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Labels returns labels of the agent defined in the inventory.
+func (inv *Inventory) Labels(agent string) map[string]string {
+	return inv.Agents[agent].Labels
+}
+
+// AllAgents returns sorted agents appear in the inventory.
+func (inv *Inventory) AllAgents() []string {
+	seen := make(map[string]bool)
+	for _, g := range inv.Groups {
+		for _, agent := range g.Agents {
+			seen[agent] = true
+		}
+	}
+	for agent := range inv.Agents {
+		seen[agent] = true
+	}
+	out := make([]string, 0, len(seen))
+	for agent := range seen {
+		out = append(out, agent)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (inv *Inventory) Validate() error {
+	for name := range inv.Groups {
+		if _, err := inv.Resolve(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
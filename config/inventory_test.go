@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const inventoryJSON = `{
+  "groups": {
+    "rack1": {"agents": ["10.0.1.1:7300", "10.0.1.2:7300"]},
+    "rack2": {"agents": ["10.0.2.1:7300"]},
+    "web": {"agents": ["10.0.1.1:7300", "10.0.2.1:7300"]},
+    "db": {"agents": ["10.0.1.2:7300"]},
+    "all": {"groups": ["rack1", "rack2"]}
+  },
+  "agents": {
+    "10.0.1.1:7300": {"labels": {"zone": "a"}}
+  }
+}`
+
+func parseInventory(t *testing.T, s string) *Inventory {
+	var inv Inventory
+	if err := json.Unmarshal([]byte(s), &inv); err != nil {
+		t.Fatal(err)
+	}
+	return &inv
+}
+
+func TestInventoryExpand(t *testing.T) {
+	inv := parseInventory(t, inventoryJSON)
+	testcase := []struct {
+		agents   []string
+		expected []string
+	}{
+		{[]string{"@web"}, []string{"10.0.1.1:7300", "10.0.2.1:7300"}},
+		{[]string{"@web", "@db"}, []string{"10.0.1.1:7300", "10.0.2.1:7300", "10.0.1.2:7300"}},
+		{[]string{"@all"}, []string{"10.0.1.1:7300", "10.0.1.2:7300", "10.0.2.1:7300"}},
+		{[]string{"localhost:7300", "@db"}, []string{"localhost:7300", "10.0.1.2:7300"}},
+	}
+	for _, c := range testcase {
+		agents, err := inv.Expand(c.agents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(agents, c.expected) {
+			t.Fatalf("%v expected %v, but %v", c.agents, c.expected, agents)
+		}
+	}
+	if _, err := inv.Expand([]string{"@unknown"}); err == nil {
+		t.Fatal("unknown group must be error")
+	}
+}
+
+func TestInventoryGroupsOf(t *testing.T) {
+	inv := parseInventory(t, inventoryJSON)
+	if groups := inv.GroupsOf("10.0.1.1:7300"); !reflect.DeepEqual(groups, []string{"all", "rack1", "web"}) {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+	if labels := inv.Labels("10.0.1.1:7300"); labels["zone"] != "a" {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+	if labels := inv.Labels("10.0.1.2:7300"); labels != nil {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+}
+
+func TestInventoryValidate(t *testing.T) {
+	inv := parseInventory(t, `{"groups": {"a": {"groups": ["b"]}, "b": {"groups": ["a"]}}}`)
+	if err := inv.Validate(); err == nil {
+		t.Fatal("circular reference must be error")
+	}
+	inv = parseInventory(t, `{"groups": {"a": {"groups": ["c"]}}}`)
+	if err := inv.Validate(); err == nil {
+		t.Fatal("unknown group must be error")
+	}
+}
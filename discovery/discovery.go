@@ -0,0 +1,129 @@
+// Package discovery finds agents on the LAN. Agents listen on a multicast
+// group or a broadcast address and answer queries from clients with their
+// announcements.
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+const maxPacketSize = 8192
+
+// Announcement describes an agent.
+type Announcement struct {
+	Name   string
+	Addr   string
+	Labels map[string]string
+}
+
+type packet struct {
+	Query        bool          `json:",omitempty"`
+	Announcement *Announcement `json:",omitempty"`
+}
+
+// Serve answers queries sent to addr with the announcement returned by the
+// function. It blocks until an error occurs.
+func Serve(addr string, a func() *Announcement) error {
+	conn, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return serve(conn, a)
+}
+
+func listen(addr string) (*net.UDPConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case udpAddr.IP.IsMulticast():
+		return net.ListenMulticastUDP("udp", nil, udpAddr)
+	case udpAddr.IP.Equal(net.IPv4bcast):
+		// limited broadcast is received by any address
+		udpAddr.IP = nil
+	}
+	return net.ListenUDP("udp", udpAddr)
+}
+
+func serve(conn *net.UDPConn, a func() *Announcement) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		var p packet
+		if err = json.Unmarshal(buf[:n], &p); err != nil || !p.Query {
+			continue
+		}
+		resp, err := json.Marshal(&packet{Announcement: a()})
+		if err != nil {
+			return err
+		}
+		conn.WriteToUDP(resp, src)
+	}
+}
+
+// Discover sends a query to addr and collects announcements until the
+// timeout expires. Agents listening on an unspecified address are reported
+// with the address the announcement came from.
+func Discover(addr string, timeout time.Duration) ([]*Announcement, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query, err := json.Marshal(&packet{Query: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.WriteToUDP(query, udpAddr); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var out []*Announcement
+	seen := make(map[string]bool)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return out, nil
+			}
+			return out, err
+		}
+		var p packet
+		if err = json.Unmarshal(buf[:n], &p); err != nil || p.Announcement == nil {
+			continue
+		}
+		a := p.Announcement
+		if a.Addr, err = FillHost(a.Addr, src.IP); err != nil || seen[a.Addr] {
+			continue
+		}
+		seen[a.Addr] = true
+		out = append(out, a)
+	}
+}
+
+// FillHost replaces an empty or unspecified host of addr with ip, which is
+// usually the source address of a packet or a connection.
+func FillHost(addr string, ip net.IP) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, port), nil
+}
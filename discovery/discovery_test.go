@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiscover(t *testing.T) {
+	var conns []*net.UDPConn
+	for _, a := range []*Announcement{
+		{Name: "agent1", Addr: ":7300", Labels: map[string]string{"zone": "a"}},
+		{Name: "agent2", Addr: "10.0.0.2:7300"},
+	} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		go func(a *Announcement) {
+			serve(conn, func() *Announcement { return a })
+		}(a)
+		conns = append(conns, conn)
+	}
+
+	var found []*Announcement
+	for _, conn := range conns {
+		v, err := Discover(conn.LocalAddr().String(), 200*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, v...)
+	}
+	expected := []*Announcement{
+		{Name: "agent1", Addr: "127.0.0.1:7300", Labels: map[string]string{"zone": "a"}},
+		{Name: "agent2", Addr: "10.0.0.2:7300"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, but %v", expected, found)
+	}
+}
+
+func TestFillHost(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 1)
+	for addr, expected := range map[string]string{
+		":7300":          "192.168.0.1:7300",
+		"0.0.0.0:7300":   "192.168.0.1:7300",
+		"[::]:7300":      "192.168.0.1:7300",
+		"10.0.0.1:7300":  "10.0.0.1:7300",
+		"localhost:7300": "localhost:7300",
+	} {
+		if s, err := FillHost(addr, ip); err != nil || s != expected {
+			t.Fatalf("%s expected %s, but %s (%v)", addr, expected, s, err)
+		}
+	}
+}
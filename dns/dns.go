@@ -0,0 +1,247 @@
+// Package dns answers DNS queries for names in a single domain. It supports
+// just enough of the protocol over UDP for containers to resolve services
+// with A and SRV records.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+const (
+	maxPacketSize = 512
+	ttl           = 10
+
+	typeA    = 1
+	typeAAAA = 28
+	typeSRV  = 33
+	classIN  = 1
+
+	rcodeFormErr  = 1
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+)
+
+var errMalformed = errors.New("Malformed DNS message")
+
+// Record is an address a name resolves to. Port and Target, the host name
+// of the address, are used for SRV records.
+type Record struct {
+	IP     net.IP
+	Port   uint16
+	Target string
+}
+
+// Lookup returns records of a name relative to the domain in lower case,
+// e.g. "redis" for "Redis.craft.". ok is false if the name doesn't exist.
+type Lookup func(name string) (records []Record, ok bool)
+
+// Serve answers queries sent to addr for names in the domain. It blocks
+// until an error occurs.
+func Serve(addr, domain string, lookup Lookup) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return serve(conn, domain, lookup)
+}
+
+func serve(conn *net.UDPConn, domain string, lookup Lookup) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		if resp, err := answer(buf[:n], domain, lookup); err == nil {
+			conn.WriteToUDP(resp, src)
+		}
+	}
+}
+
+type question struct {
+	name  string
+	qtype uint16
+	end   int // offset next to the question
+}
+
+// answer returns the response to the query. Queries too broken to respond
+// to are dropped with an error.
+func answer(query []byte, domain string, lookup Lookup) ([]byte, error) {
+	if len(query) < 12 || query[2]&0x80 != 0 {
+		return nil, errMalformed
+	}
+	w := &writer{}
+	w.buf = append(w.buf, query[:4]...)
+	w.buf[2] = 0x80 | query[2]&0x79 // QR, opcode and RD
+	w.buf[3] = 0
+	w.buf = append(w.buf, make([]byte, 8)...)
+
+	opcode := query[2] >> 3 & 0xf
+	q, err := parseQuestion(query)
+	if err != nil || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return w.finish(rcodeFormErr), nil
+	}
+	w.buf = append(w.buf, query[12:q.end]...)
+	binary.BigEndian.PutUint16(w.buf[4:], 1)
+	if opcode != 0 {
+		return w.finish(rcodeNotImp), nil
+	}
+
+	suffix := "." + strings.Trim(strings.ToLower(domain), ".") + "."
+	name := strings.ToLower(q.name)
+	if !strings.HasSuffix(name, suffix) {
+		return w.finish(rcodeRefused), nil
+	}
+	w.buf[2] |= 0x04 // authoritative
+	records, ok := lookup(strings.TrimSuffix(name, suffix))
+	if !ok {
+		return w.finish(rcodeNXDomain), nil
+	}
+
+	switch q.qtype {
+	case typeA, typeAAAA:
+		seen := make(map[string]bool)
+		for _, r := range records {
+			if ip := address(r.IP, q.qtype); ip != nil && !seen[ip.String()] {
+				seen[ip.String()] = true
+				w.answer(typeA, ip, 0, "")
+			}
+		}
+	case typeSRV:
+		for _, r := range records {
+			if r.Target != "" {
+				w.answer(typeSRV, nil, r.Port, r.Target)
+			}
+		}
+		// addresses of targets save another round trip
+		seen := make(map[string]bool)
+		for _, r := range records {
+			if ip := r.IP.To4(); ip != nil && r.Target != "" && !seen[r.Target] {
+				seen[r.Target] = true
+				w.additional(r.Target, ip)
+			}
+		}
+	}
+	return w.finish(0), nil
+}
+
+func parseQuestion(msg []byte) (*question, error) {
+	var labels []string
+	off := 12
+	for {
+		if off >= len(msg) {
+			return nil, errMalformed
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		// compression pointers are not expected in queries
+		if n > 63 || off+n > len(msg) {
+			return nil, errMalformed
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+	if off+4 > len(msg) {
+		return nil, errMalformed
+	}
+	return &question{
+		name:  strings.Join(labels, ".") + ".",
+		qtype: binary.BigEndian.Uint16(msg[off:]),
+		end:   off + 4,
+	}, nil
+}
+
+func address(ip net.IP, qtype uint16) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		if qtype == typeA {
+			return v4
+		}
+		return nil
+	}
+	if qtype == typeAAAA && len(ip) == net.IPv6len {
+		return ip
+	}
+	return nil
+}
+
+// writer builds a response. Records which don't fit in a UDP packet are
+// dropped with the truncated flag set.
+type writer struct {
+	buf       []byte
+	truncated bool
+}
+
+func (w *writer) answer(rtype uint16, ip net.IP, port uint16, target string) {
+	if w.truncated {
+		return
+	}
+	rr := []byte{0xc0, 12} // points to the question name
+	if ip != nil {
+		if len(ip) == net.IPv6len {
+			rtype = typeAAAA
+		}
+		rr = appendHeader(rr, rtype, len(ip))
+		rr = append(rr, ip...)
+	} else {
+		name := encodeName(target)
+		rr = appendHeader(rr, rtype, 6+len(name))
+		rr = append(rr, 0, 0, 0, 0, byte(port>>8), byte(port)) // priority, weight
+		rr = append(rr, name...)
+	}
+	if !w.add(rr, 6) {
+		w.truncated = true
+	}
+}
+
+func (w *writer) additional(name string, ip net.IP) {
+	rr := encodeName(name)
+	rr = appendHeader(rr, typeA, len(ip))
+	rr = append(rr, ip...)
+	// missing additional records don't make the response truncated
+	w.add(rr, 10)
+}
+
+func (w *writer) add(rr []byte, count int) bool {
+	if len(w.buf)+len(rr) > maxPacketSize {
+		return false
+	}
+	w.buf = append(w.buf, rr...)
+	binary.BigEndian.PutUint16(w.buf[count:], binary.BigEndian.Uint16(w.buf[count:])+1)
+	return true
+}
+
+func (w *writer) finish(rcode byte) []byte {
+	if w.truncated {
+		w.buf[2] |= 0x02
+	}
+	w.buf[3] = rcode
+	return w.buf
+}
+
+func appendHeader(b []byte, rtype uint16, length int) []byte {
+	return append(b,
+		byte(rtype>>8), byte(rtype), 0, classIN,
+		0, 0, byte(ttl>>8), byte(ttl),
+		byte(length>>8), byte(length))
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testResolver(t *testing.T) (*net.Resolver, *net.UDPConn) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(conn, "craft", func(name string) ([]Record, bool) {
+		switch name {
+		case "redis":
+			return []Record{
+				{IP: net.ParseIP("10.0.0.1"), Port: 49153, Target: "agent1.node.craft."},
+				{IP: net.ParseIP("10.0.0.2"), Port: 6379, Target: "agent2.node.craft."},
+			}, true
+		case "web":
+			return []Record{{IP: net.ParseIP("fd00::1"), Port: 80, Target: "agent3.node.craft."}}, true
+		case "idle":
+			return nil, true
+		}
+		return nil, false
+	})
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return net.Dial("udp", conn.LocalAddr().String())
+		},
+	}, conn
+}
+
+func TestLookupHost(t *testing.T) {
+	r, conn := testResolver(t)
+	defer conn.Close()
+	addrs, err := r.LookupHost(context.Background(), "Redis.craft.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(addrs)
+	if expected := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected %v, but %v", expected, addrs)
+	}
+
+	addrs, err = r.LookupHost(context.Background(), "web.craft.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"fd00::1"}; !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected %v, but %v", expected, addrs)
+	}
+
+	for _, name := range []string{"unknown.craft.", "idle.craft.", "redis.example.com."} {
+		if addrs, err = r.LookupHost(context.Background(), name); err == nil {
+			t.Fatalf("%s: expected an error, but %v", name, addrs)
+		}
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	r, conn := testResolver(t)
+	defer conn.Close()
+	_, srvs, err := r.LookupSRV(context.Background(), "", "", "redis.craft.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, srv := range srvs {
+		found = append(found, fmt.Sprintf("%s:%d", srv.Target, srv.Port))
+	}
+	sort.Strings(found)
+	expected := []string{"agent1.node.craft.:49153", "agent2.node.craft.:6379"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("expected %v, but %v", expected, found)
+	}
+}
+
+func TestAnswerMalformed(t *testing.T) {
+	lookup := func(string) ([]Record, bool) { return nil, true }
+	if _, err := answer([]byte{0, 1, 0}, "craft", lookup); err == nil {
+		t.Fatal("expected an error for a short message")
+	}
+	// a question whose label overruns the message
+	query := []byte{0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0, 10, 'a'}
+	resp, err := answer(query, "craft", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp[3]&0xf != rcodeFormErr {
+		t.Fatalf("expected FORMERR, but rcode %d", resp[3]&0xf)
+	}
+}
+
+func TestAnswerTruncated(t *testing.T) {
+	var records []Record
+	for i := 0; i < 64; i++ {
+		records = append(records, Record{IP: net.IPv4(10, 0, 1, byte(i)), Port: 80, Target: "agent.node.craft."})
+	}
+	lookup := func(string) ([]Record, bool) { return records, true }
+	query := []byte{0, 1, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	query = append(query, encodeName("many.craft.")...)
+	query = append(query, 0, typeA, 0, classIN)
+	resp, err := answer(query, "craft", lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) > maxPacketSize || resp[2]&0x02 == 0 {
+		t.Fatalf("expected a truncated response, but %d bytes with flags %x", len(resp), resp[2])
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/fsouza/go-dockerclient"
 )
@@ -43,6 +44,11 @@ func (m *Manifest) Validate() error {
 	if m.NetworkMode != "" && !validNetworkMode.MatchString(m.NetworkMode) {
 		return fmt.Errorf("Invalid network mode: %s", m.NetworkMode)
 	}
+	for _, l := range m.Links {
+		if l.Mode != "" || l.Network != "" {
+			return fmt.Errorf("Link mode and network are allowed only in exlinks: %s", l.Name)
+		}
+	}
 	if m.ReplaceWait == 0 {
 		m.ReplaceWait = 10
 	}
@@ -110,10 +116,36 @@ func (m *Manifest) MergeEnv(env map[string]string) {
 	}
 }
 
+// Host ports allocated by the agent when a port spec has no fixed one.
+const (
+	DynamicPortMin = 49153
+	DynamicPortMax = 65535
+)
+
+// PortSpec publishes a container port. A host port can be fixed, or left to
+// the agent to allocate from the range HostPortMin to HostPortMax.
 type PortSpec struct {
-	Exposed  docker.Port
-	HostIP   string
-	HostPort int64
+	Exposed     docker.Port
+	HostIP      string
+	HostPort    int64
+	HostPortMin int64
+	HostPortMax int64
+}
+
+// Dynamic reports whether the agent allocates the host port.
+func (s *PortSpec) Dynamic() bool {
+	return s.HostPort == 0 && s.HostPortMax > 0
+}
+
+func (s *PortSpec) String() string {
+	host := fmt.Sprintf("%d", s.HostPort)
+	if s.Dynamic() {
+		host = fmt.Sprintf("%d-%d", s.HostPortMin, s.HostPortMax)
+	}
+	if s.HostIP != "" {
+		host = s.HostIP + ":" + host
+	}
+	return host + "->" + string(s.Exposed)
 }
 
 func (s *PortSpec) UnmarshalJSON(b []byte) (err error) {
@@ -125,15 +157,39 @@ func (s *PortSpec) UnmarshalJSON(b []byte) (err error) {
 		s.Exposed = docker.Port(bytes.TrimSpace(items[1]))
 		parts := bytes.Split(bytes.TrimSpace(items[0]), []byte{':'})
 		if len(parts) == 1 {
-			s.HostPort, err = strconv.ParseInt(string(parts[0]), 10, 64)
+			err = s.parseHostPort(string(parts[0]))
 		} else {
 			s.HostIP = string(parts[0])
-			s.HostPort, err = strconv.ParseInt(string(parts[1]), 10, 64)
+			err = s.parseHostPort(string(parts[1]))
 		}
 	}
 	return
 }
 
+// parseHostPort parses a fixed port, a range such as 8000-8100 or an empty
+// string meaning any port.
+func (s *PortSpec) parseHostPort(v string) (err error) {
+	if v == "" {
+		s.HostPortMin, s.HostPortMax = DynamicPortMin, DynamicPortMax
+		return nil
+	}
+	n := strings.Index(v, "-")
+	if n == -1 {
+		s.HostPort, err = strconv.ParseInt(v, 10, 64)
+		return
+	}
+	if s.HostPortMin, err = strconv.ParseInt(v[:n], 10, 64); err != nil {
+		return
+	}
+	if s.HostPortMax, err = strconv.ParseInt(v[n+1:], 10, 64); err != nil {
+		return
+	}
+	if s.HostPortMin <= 0 || s.HostPortMin > s.HostPortMax {
+		return fmt.Errorf("Invalid host port range: %s", v)
+	}
+	return nil
+}
+
 type MountSpec struct {
 	Path   string
 	Target string
@@ -155,13 +211,33 @@ func (s *MountSpec) String() string {
 	return s.Path + ":" + s.Target
 }
 
+// Modes of an exlink to choose among containers running on several agents.
+const (
+	LinkRandom = "random" // one at random
+	LinkZone   = "zone"   // one in the same zone as the linking container if any
+	LinkHash   = "hash"   // the same one as long as its agent is available
+	LinkAll    = "all"    // one at random, and all as *_ENDPOINTS
+)
+
+// Link is written as NAME[:ALIAS[:MODE]][@NETWORK]. Mode and network are
+// allowed only in exlinks, where an empty mode is random. Network is a name
+// defined by agents or a CIDR to choose the address of the linked container.
 type Link struct {
-	Name  string
-	Alias string
+	Name    string
+	Alias   string
+	Mode    string
+	Network string
 }
 
 func (l *Link) UnmarshalJSON(b []byte) error {
+	*l = Link{}
 	b = bytes.Trim(b, `"`)
+	if n := bytes.LastIndex(b, []byte("@")); n != -1 {
+		if l.Network = string(b[n+1:]); l.Network == "" {
+			return fmt.Errorf("Invalid link network: %s", b)
+		}
+		b = b[:n]
+	}
 	items := bytes.Split(b, []byte(":"))
 	l.Name = string(items[0])
 	if len(items) == 1 {
@@ -169,7 +245,14 @@ func (l *Link) UnmarshalJSON(b []byte) error {
 	} else {
 		l.Alias = string(items[1])
 	}
-	return nil
+	if len(items) > 2 {
+		l.Mode = string(items[2])
+	}
+	switch l.Mode {
+	case "", LinkRandom, LinkZone, LinkHash, LinkAll:
+		return nil
+	}
+	return fmt.Errorf("Invalid link mode: %s", l.Mode)
 }
 
 func (l *Link) String() string {
@@ -190,6 +273,7 @@ type Restrict struct {
 	Agent     string
 	Labels    map[string]string
 	Conflicts []string
+	Expr      string // a filter expression, which is validated by the client
 }
 
 func (r *Restrict) Validate() error {
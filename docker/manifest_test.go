@@ -37,7 +37,7 @@ func (s *ManifestSuite) TestPort(c *C) {
 	m := &Manifest{
 		Ports: []PortSpec{
 			{Exposed: "80/tcp"},
-			{"443/tcp", "", 443},
+			{Exposed: "443/tcp", HostPort: 443},
 		},
 	}
 	c.Assert(m.ExposedPorts(), DeepEquals, map[docker.Port]struct{}{
@@ -50,6 +50,20 @@ func (s *ManifestSuite) TestPort(c *C) {
 	})
 }
 
+func (s *ManifestSuite) TestValidateLinks(c *C) {
+	var m Manifest
+	text := `{"image": "app", "links": ["db:db"], "exlinks": ["cache:cache:zone@storage"]}`
+	c.Assert(json.Unmarshal([]byte(text), &m), IsNil)
+	c.Assert(m.Validate(), IsNil)
+
+	for _, link := range []string{"db:db:all", "db@storage"} {
+		m = Manifest{}
+		text = `{"image": "app", "links": ["` + link + `"]}`
+		c.Assert(json.Unmarshal([]byte(text), &m), IsNil)
+		c.Assert(m.Validate(), ErrorMatches, "Link mode and network are allowed only in exlinks: db", Commentf(link))
+	}
+}
+
 func (s *ManifestSuite) TestMergeEnv(c *C) {
 	m := &Manifest{}
 	m.MergeEnv(map[string]string{"ID": "1"})
@@ -83,6 +97,34 @@ func (s *PortSpecSuite) TestUnmarshal(c *C) {
 	c.Assert(ports[2].HostPort, Equals, int64(80))
 }
 
+func (s *PortSpecSuite) TestUnmarshalDynamic(c *C) {
+	text := `["-> 80/tcp", "8000-8100 -> 80/tcp", "127.0.0.1:8000-8100 -> 80/tcp"]`
+	var ports []PortSpec
+	err := json.Unmarshal([]byte(text), &ports)
+	c.Assert(err, IsNil)
+	c.Assert(ports, HasLen, 3)
+
+	c.Assert(ports[0].Dynamic(), Equals, true)
+	c.Assert(ports[0].HostPortMin, Equals, int64(DynamicPortMin))
+	c.Assert(ports[0].HostPortMax, Equals, int64(DynamicPortMax))
+
+	c.Assert(ports[1].Dynamic(), Equals, true)
+	c.Assert(ports[1].HostPortMin, Equals, int64(8000))
+	c.Assert(ports[1].HostPortMax, Equals, int64(8100))
+	c.Assert(ports[1].String(), Equals, "8000-8100->80/tcp")
+
+	c.Assert(ports[2].HostIP, Equals, "127.0.0.1")
+	c.Assert(ports[2].String(), Equals, "127.0.0.1:8000-8100->80/tcp")
+
+	ports[1].HostPort = 8000
+	c.Assert(ports[1].Dynamic(), Equals, false)
+	c.Assert(ports[1].String(), Equals, "8000->80/tcp")
+
+	for _, text := range []string{`["8100-8000->80/tcp"]`, `["0-10->80/tcp"]`, `["a-b->80/tcp"]`} {
+		c.Assert(json.Unmarshal([]byte(text), &ports), NotNil, Commentf(text))
+	}
+}
+
 type VolumeSpecSuite struct{}
 
 var _ = Suite(&VolumeSpecSuite{})
@@ -124,6 +166,38 @@ func (s *LinkSuite) TestUnmarshal(c *C) {
 	c.Assert(links[1].Alias, Equals, "alias")
 }
 
+func (s *LinkSuite) TestUnmarshalMode(c *C) {
+	var links []Link
+	text := `["name", "name:alias:all", "name:name:zone"]`
+	err := json.Unmarshal([]byte(text), &links)
+	c.Assert(err, IsNil)
+	c.Assert(links, HasLen, 3)
+
+	c.Assert(links[0].Mode, Equals, "")
+	c.Assert(links[1].Alias, Equals, "alias")
+	c.Assert(links[1].Mode, Equals, LinkAll)
+	c.Assert(links[2].Mode, Equals, LinkZone)
+	c.Assert(links[2].String(), Equals, "name:name")
+
+	err = json.Unmarshal([]byte(`["name:alias:nearest"]`), &links)
+	c.Assert(err, ErrorMatches, "Invalid link mode: nearest")
+}
+
+func (s *LinkSuite) TestUnmarshalNetwork(c *C) {
+	var links []Link
+	text := `["name@storage", "name:alias:all@fd00::/8", "name"]`
+	err := json.Unmarshal([]byte(text), &links)
+	c.Assert(err, IsNil)
+	c.Assert(links, DeepEquals, []Link{
+		{Name: "name", Alias: "name", Network: "storage"},
+		{Name: "name", Alias: "alias", Mode: LinkAll, Network: "fd00::/8"},
+		{Name: "name", Alias: "name"},
+	})
+
+	err = json.Unmarshal([]byte(`["name@"]`), &links)
+	c.Assert(err, ErrorMatches, "Invalid link network: name@")
+}
+
 func (s LinkSuite) TestString(c *C) {
 	l := Link{Name: "name", Alias: "alias"}
 	c.Assert(l.String(), Equals, "name:alias")
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdEvents struct {
+	Names    []string `short:"n" long:"name" description:"Show events of the container only"`
+	Statuses []string `short:"e" long:"event" description:"Show events of the type only (e.g. start, die)"`
+	Since    string   `long:"since" description:"Show events created since timestamp (RFC3339) or duration ago"`
+	Until    string   `long:"until" description:"Stream events until timestamp (RFC3339) or duration from now"`
+}
+
+func (opts *CmdEvents) Execute(args []string) error {
+	req := rpc.EventsRequest{
+		Names:    opts.Names,
+		Statuses: opts.Statuses,
+	}
+	var err error
+	if req.Since, err = parseTime(opts.Since, -1); err != nil {
+		log.WithField("error", err).Fatal("Invalid since value")
+	}
+	if req.Until, err = parseTime(opts.Until, 1); err != nil {
+		log.WithField("error", err).Fatal("Invalid until value")
+	}
+	rpc.Events(gopts.agents(), req)
+	return nil
+}
+
+// parseTime parses s as RFC3339 timestamp or duration relative to now and
+// returns it as unix time. The sign decides the direction of the duration.
+func parseTime(s string, sign time.Duration) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(sign * d).Unix(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+func init() {
+	parser.AddCommand("events", "Stream container events across agents", "", &CmdEvents{})
+}
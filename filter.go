@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/filter"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdFilter struct{}
+
+type CmdFilterTest struct {
+	Args struct {
+		Expr string `positional-arg-name:"EXPR"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (opts *CmdFilterTest) Execute(args []string) error {
+	expr, err := filter.Parse(opts.Args.Expr)
+	if err != nil {
+		printFilterError(err)
+		log.WithField("error", err).Fatal("Invalid filter")
+	}
+	if gopts.conf == nil {
+		gopts.ParseConfig()
+	}
+
+	agents := append([]string(nil), gopts.conf.Agents...)
+	sort.Strings(agents)
+	caps := gatherCapabilities(agents)
+	for _, agent := range agents {
+		cap, ok := caps[agent]
+		state := ""
+		if !ok {
+			// evaluate with what the inventory knows
+			cap = &rpc.Capability{}
+			annotateCapability(agent, cap)
+			state = " (unreachable)"
+		}
+		if expr.Eval(cap) {
+			fmt.Printf("[%s] matched%s\n", agent, state)
+		} else {
+			fmt.Printf("[%s] not matched%s\n", agent, state)
+		}
+		for _, line := range filter.Explain(expr, cap) {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	return nil
+}
+
+func init() {
+	cmd, err := parser.AddCommand("filter", "Filter expression utilities", "", &CmdFilter{})
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand("test", "Show which agents match the expression and why", "", &CmdFilterTest{})
+}
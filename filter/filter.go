@@ -2,8 +2,12 @@ package filter
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/yosisa/craft/rpc"
 )
@@ -11,10 +15,12 @@ import (
 var (
 	ErrInvalidSyntax  = errors.New("Error invalid syntax")
 	ErrStackRemaining = errors.New("Error stack remaining")
+	ErrStackEmpty     = errors.New("Error stack empty")
 )
 
 type Evaluator interface {
 	Eval(*rpc.Capability) bool
+	String() string
 }
 
 type not struct {
@@ -25,6 +31,10 @@ func (e *not) Eval(cap *rpc.Capability) bool {
 	return !e.e.Eval(cap)
 }
 
+func (e *not) String() string {
+	return "not " + e.e.String()
+}
+
 type and struct {
 	l Evaluator
 	r Evaluator
@@ -34,6 +44,10 @@ func (e *and) Eval(cap *rpc.Capability) bool {
 	return e.l.Eval(cap) && e.r.Eval(cap)
 }
 
+func (e *and) String() string {
+	return "(" + e.l.String() + " and " + e.r.String() + ")"
+}
+
 type or struct {
 	l Evaluator
 	r Evaluator
@@ -43,6 +57,10 @@ func (e *or) Eval(cap *rpc.Capability) bool {
 	return e.l.Eval(cap) || e.r.Eval(cap)
 }
 
+func (e *or) String() string {
+	return "(" + e.l.String() + " or " + e.r.String() + ")"
+}
+
 type agent struct {
 	re *regexp.Regexp
 }
@@ -51,6 +69,10 @@ func (e *agent) Eval(cap *rpc.Capability) bool {
 	return e.re.MatchString(cap.Agent)
 }
 
+func (e *agent) String() string {
+	return "A@" + e.re.String()
+}
+
 type label struct {
 	name  string
 	value string
@@ -61,27 +83,312 @@ func (e *label) Eval(cap *rpc.Capability) bool {
 	return ok && v == e.value
 }
 
+func (e *label) String() string {
+	return "L@" + e.name + ":" + e.value
+}
+
+func (e *label) reason(cap *rpc.Capability) string {
+	return labelReason(cap, e.name)
+}
+
+type labelExists struct {
+	name string
+}
+
+func (e *labelExists) Eval(cap *rpc.Capability) bool {
+	_, ok := cap.Labels[e.name]
+	return ok
+}
+
+func (e *labelExists) String() string {
+	return "L@" + e.name
+}
+
+func (e *labelExists) reason(cap *rpc.Capability) string {
+	return labelReason(cap, e.name)
+}
+
+type labelRegexp struct {
+	name string
+	re   *regexp.Regexp
+}
+
+func (e *labelRegexp) Eval(cap *rpc.Capability) bool {
+	v, ok := cap.Labels[e.name]
+	return ok && e.re.MatchString(v)
+}
+
+func (e *labelRegexp) String() string {
+	return "L@" + e.name + "~" + e.re.String()
+}
+
+func (e *labelRegexp) reason(cap *rpc.Capability) string {
+	return labelReason(cap, e.name)
+}
+
+type labelCompare struct {
+	name  string
+	op    string
+	value float64
+}
+
+func (e *labelCompare) Eval(cap *rpc.Capability) bool {
+	s, ok := cap.Labels[e.name]
+	if !ok {
+		return false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return v < e.value
+	case "<=":
+		return v <= e.value
+	case ">":
+		return v > e.value
+	case ">=":
+		return v >= e.value
+	}
+	return false
+}
+
+func (e *labelCompare) String() string {
+	return "L@" + e.name + e.op + strconv.FormatFloat(e.value, 'f', -1, 64)
+}
+
+func (e *labelCompare) reason(cap *rpc.Capability) string {
+	return labelReason(cap, e.name)
+}
+
+func labelReason(cap *rpc.Capability, name string) string {
+	if v, ok := cap.Labels[name]; ok {
+		return name + "=" + v
+	}
+	return name + " is not set"
+}
+
+type container struct {
+	re *regexp.Regexp
+}
+
+func (e *container) Eval(cap *rpc.Capability) bool {
+	return e.find(cap) != ""
+}
+
+func (e *container) String() string {
+	return "C@" + e.re.String()
+}
+
+func (e *container) reason(cap *rpc.Capability) string {
+	if name := e.find(cap); name != "" {
+		return "container " + name
+	}
+	return ""
+}
+
+func (e *container) find(cap *rpc.Capability) string {
+	for _, name := range cap.UsedNames {
+		if e.re.MatchString(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+type port struct {
+	port int64
+}
+
+func (e *port) Eval(cap *rpc.Capability) bool {
+	for _, p := range cap.UsedPorts {
+		if p == e.port {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *port) String() string {
+	return "P@" + strconv.FormatInt(e.port, 10)
+}
+
+type network struct {
+	ipnet *net.IPNet
+}
+
+func (e *network) Eval(cap *rpc.Capability) bool {
+	return e.find(cap) != ""
+}
+
+func (e *network) String() string {
+	return "N@" + e.ipnet.String()
+}
+
+func (e *network) reason(cap *rpc.Capability) string {
+	if addr := e.find(cap); addr != "" {
+		return "address " + addr
+	}
+	return ""
+}
+
+func (e *network) find(cap *rpc.Capability) string {
+	for _, addr := range cap.IPAddrs {
+		if ip := net.ParseIP(addr); ip != nil && e.ipnet.Contains(ip) {
+			return addr
+		}
+	}
+	return ""
+}
+
+type group struct {
+	name string
+}
+
+func (e *group) Eval(cap *rpc.Capability) bool {
+	for _, g := range cap.Groups {
+		if g == e.name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *group) String() string {
+	return "G@" + e.name
+}
+
+// reasoner is implemented by evaluators which can tell the facts of the
+// capability they are based on.
+type reasoner interface {
+	reason(*rpc.Capability) string
+}
+
+// Explain evaluates e against cap and returns the result of each node of
+// the expression as indented lines.
+func Explain(e Evaluator, cap *rpc.Capability) []string {
+	return explain(nil, e, cap, "")
+}
+
+func explain(lines []string, e Evaluator, cap *rpc.Capability, indent string) []string {
+	var name string
+	var children []Evaluator
+	switch v := e.(type) {
+	case *not:
+		name, children = "not", []Evaluator{v.e}
+	case *and:
+		name, children = "and", []Evaluator{v.l, v.r}
+	case *or:
+		name, children = "or", []Evaluator{v.l, v.r}
+	default:
+		name = e.String()
+	}
+	line := fmt.Sprintf("%s%s: %v", indent, name, e.Eval(cap))
+	if r, ok := e.(reasoner); ok {
+		if s := r.reason(cap); s != "" {
+			line += " (" + s + ")"
+		}
+	}
+	lines = append(lines, line)
+	for _, c := range children {
+		lines = explain(lines, c, cap, indent+"  ")
+	}
+	return lines
+}
+
 func (p *parser) pushStack(e Evaluator) {
 	p.stack = append(p.stack, e)
 }
 
 func (p *parser) popStack() (e Evaluator) {
 	n := len(p.stack)
+	if n == 0 {
+		p.fail(0, ErrStackEmpty)
+		return nil
+	}
 	e, p.stack = p.stack[n-1], p.stack[:n-1]
 	return
 }
 
-func (p *parser) Agent(s string) {
+// fail records the first error occurred while executing actions.
+func (p *parser) fail(offset int, err error) {
+	if p.err == nil {
+		p.err = &SyntaxError{Expr: p.Buffer, Offset: offset, Err: err}
+	}
+}
+
+func (p *parser) Agent(begin int, s string) {
 	re, err := regexp.Compile(s)
 	if err != nil {
-		p.err = err
+		p.fail(begin, err)
 	}
 	p.pushStack(&agent{re})
 }
 
-func (p *parser) Label(s string) {
-	parts := strings.SplitN(s, ":", 2)
-	p.pushStack(&label{name: parts[0], value: parts[1]})
+func (p *parser) Label(begin int, s string) {
+	i := strings.IndexAny(s, ":~<>")
+	if i < 0 {
+		p.pushStack(&labelExists{s})
+		return
+	}
+	name, op, value := s[:i], s[i:i+1], s[i+1:]
+	if strings.HasPrefix(value, "=") {
+		op, value = op+"=", value[1:]
+	}
+	begin += utf8.RuneCountInString(s[:len(s)-len(value)])
+	switch op {
+	case ":":
+		p.pushStack(&label{name: name, value: value})
+	case "~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			p.fail(begin, err)
+		}
+		p.pushStack(&labelRegexp{name, re})
+	default:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			p.fail(begin, err)
+		}
+		p.pushStack(&labelCompare{name, op, v})
+	}
+}
+
+func (p *parser) Container(begin int, s string) {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		p.fail(begin, err)
+	}
+	p.pushStack(&container{re})
+}
+
+func (p *parser) Port(begin int, s string) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.fail(begin, err)
+	}
+	p.pushStack(&port{n})
+}
+
+func (p *parser) Network(begin int, s string) {
+	if !strings.Contains(s, "/") {
+		// a single address
+		if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		p.fail(begin, err)
+	}
+	p.pushStack(&network{ipnet})
+}
+
+func (p *parser) Group(begin int, s string) {
+	p.pushStack(&group{s})
 }
 
 func (p *parser) Not() {
@@ -89,25 +396,137 @@ func (p *parser) Not() {
 }
 
 func (p *parser) And() {
-	p.pushStack(&and{p.popStack(), p.popStack()})
+	r, l := p.popStack(), p.popStack()
+	p.pushStack(&and{l, r})
 }
 
 func (p *parser) Or() {
-	p.pushStack(&or{p.popStack(), p.popStack()})
+	r, l := p.popStack(), p.popStack()
+	p.pushStack(&or{l, r})
 }
 
 func Parse(s string) (Evaluator, error) {
 	p := parser{Buffer: s}
 	p.Init()
 	if err := p.Parse(); err != nil {
-		return nil, ErrInvalidSyntax
+		return nil, syntaxError(s)
 	}
 	p.Execute()
 	if p.err != nil {
 		return nil, p.err
 	}
-	if len(p.stack) > 1 {
-		return nil, ErrStackRemaining
+	if len(p.stack) != 1 {
+		return nil, &SyntaxError{Expr: s, Err: ErrStackRemaining}
 	}
 	return p.stack[0], nil
 }
+
+// SyntaxError describes why and where an expression is invalid. Offset is
+// counted in characters. Err holds the cause if the expression is valid
+// in grammar but has an invalid value such as a broken regexp.
+type SyntaxError struct {
+	Expr     string
+	Offset   int
+	Expected []string
+	Err      error
+}
+
+func (e *SyntaxError) Error() string {
+	var s string
+	if e.Err != nil {
+		s = fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+	} else if r := []rune(e.Expr); e.Offset < len(r) {
+		s = fmt.Sprintf("unexpected %q at offset %d", r[e.Offset], e.Offset)
+	} else {
+		s = fmt.Sprintf("unexpected end of input at offset %d", e.Offset)
+	}
+	if len(e.Expected) > 0 {
+		s += ", expected " + strings.Join(e.Expected, ", ")
+	}
+	return s
+}
+
+// Caret returns the expression followed by a line which points the offset.
+func (e *SyntaxError) Caret() string {
+	return e.Expr + "\n" + strings.Repeat(" ", e.Offset) + "^"
+}
+
+// probes are tokens which may follow a valid prefix of an expression. Each
+// has a sample to complete the prefix so that whether the token is allowed
+// there can be checked by parsing.
+var probes = []struct {
+	token  string
+	sample string
+}{
+	{`"("`, "(A@x)"},
+	{`"not"`, "not A@x"},
+	{`"A@"`, "A@x"},
+	{`"L@"`, "L@x"},
+	{`"C@"`, "C@x"},
+	{`"P@"`, "P@1"},
+	{`"N@"`, "N@1"},
+	{`"G@"`, "G@x"},
+	{`"and"`, " and A@x"},
+	{`"or"`, " or A@x"},
+}
+
+// syntaxError finds the longest prefix of s which can be completed to a
+// valid expression, and reports the tokens which are allowed after it.
+func syntaxError(s string) error {
+	r := []rune(s)
+	for offset := len(r); offset >= 0; offset-- {
+		if expected := expectedTokens(string(r[:offset])); len(expected) > 0 {
+			return &SyntaxError{Expr: s, Offset: offset, Expected: expected}
+		}
+	}
+	return &SyntaxError{Expr: s, Err: ErrInvalidSyntax}
+}
+
+func expectedTokens(prefix string) []string {
+	depth := strings.Count(prefix, "(") - strings.Count(prefix, ")")
+	if depth < 0 {
+		return nil
+	}
+	closing := strings.Repeat(")", depth)
+	valid := func(s string) bool {
+		return valid(prefix + s + closing)
+	}
+
+	// in the middle of a value, samples not separated by a space are just
+	// parts of the value
+	inValue := valid("x")
+	var tokens []string
+	for _, probe := range probes {
+		if inValue && probe.sample[0] != ' ' {
+			continue
+		}
+		if valid(probe.sample) {
+			tokens = append(tokens, probe.token)
+		}
+	}
+	if valid("") {
+		if depth > 0 {
+			tokens = append(tokens, `")"`)
+		} else {
+			tokens = append(tokens, "end of input")
+		}
+	}
+
+	switch {
+	case len(tokens) > 0:
+		return tokens
+	case inValue:
+		return []string{"value"}
+	case valid("1"):
+		return []string{"number"}
+	case valid(" A@x"):
+		return []string{"space"}
+	}
+	return nil
+}
+
+func valid(s string) bool {
+	p := parser{Buffer: s}
+	p.Init()
+	return p.Parse() == nil
+}
@@ -1,6 +1,8 @@
 package filter
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/yosisa/craft/rpc"
@@ -27,6 +29,33 @@ func TestFilter(t *testing.T) {
 		{"L@env:prd or L@role:db", &rpc.Capability{Labels: map[string]string{"role": "db"}}, true},
 		{"L@env:prd and L@role:db", &rpc.Capability{Labels: map[string]string{"role": "db"}}, false},
 		{"L@env:prd and L@role:db", &rpc.Capability{Labels: map[string]string{"env": "prd", "role": "db"}}, true},
+		{"L@gpu", &rpc.Capability{Labels: map[string]string{"gpu": ""}}, true},
+		{"L@gpu", &rpc.Capability{Labels: map[string]string{"env": "prd"}}, false},
+		{"not L@gpu and L@env:prd", &rpc.Capability{Labels: map[string]string{"env": "prd"}}, true},
+		{"L@zone~^(a|b)$", &rpc.Capability{Labels: map[string]string{"zone": "b"}}, true},
+		{"L@zone~^(a|b)$", &rpc.Capability{Labels: map[string]string{"zone": "c"}}, false},
+		{"L@mem>=64", &rpc.Capability{Labels: map[string]string{"mem": "64"}}, true},
+		{"L@mem>64", &rpc.Capability{Labels: map[string]string{"mem": "64"}}, false},
+		{"L@mem<=64", &rpc.Capability{Labels: map[string]string{"mem": "32"}}, true},
+		{"L@mem<32.5", &rpc.Capability{Labels: map[string]string{"mem": "32"}}, true},
+		{"L@mem>-1", &rpc.Capability{Labels: map[string]string{"mem": "0"}}, true},
+		{"L@mem>=64", &rpc.Capability{Labels: map[string]string{"mem": "large"}}, false},
+		{"L@mem>=64", &rpc.Capability{}, false},
+		{"C@redis", &rpc.Capability{UsedNames: []string{"api", "redis-1"}}, true},
+		{"C@^redis$", &rpc.Capability{UsedNames: []string{"api", "redis-1"}}, false},
+		{"not C@redis", &rpc.Capability{}, true},
+		{"P@8080", &rpc.Capability{UsedPorts: []int64{80, 8080}}, true},
+		{"P@8080", &rpc.Capability{UsedPorts: []int64{80}}, false},
+		{"N@10.0.0.0/8", &rpc.Capability{IPAddrs: []string{"192.168.1.1", "10.1.2.3"}}, true},
+		{"N@10.0.0.0/8", &rpc.Capability{IPAddrs: []string{"192.168.1.1"}}, false},
+		{"N@192.168.1.1", &rpc.Capability{IPAddrs: []string{"192.168.1.1"}}, true},
+		{"N@fd00::/8", &rpc.Capability{IPAddrs: []string{"fd00::1"}}, true},
+		{"N@fd00::/8 and not n@10.0.0.0/8", &rpc.Capability{IPAddrs: []string{"fd00::1"}}, true},
+		{"G@web", &rpc.Capability{Groups: []string{"rack1", "web"}}, true},
+		{"G@web and not G@rack1", &rpc.Capability{Groups: []string{"rack1", "web"}}, false},
+		{"G@db", &rpc.Capability{}, false},
+		{"L@zone:a or (L@zone:b and not A@old.*)", &rpc.Capability{Agent: "old-1", Labels: map[string]string{"zone": "b"}}, false},
+		{"L@zone:a or (L@zone:b and not A@old.*)", &rpc.Capability{Agent: "new-1", Labels: map[string]string{"zone": "b"}}, true},
 	}
 	for _, c := range testcase {
 		e, err := Parse(c.expr)
@@ -44,8 +73,19 @@ func TestInvalidFilter(t *testing.T) {
 		"name1",
 		"A@name[1",
 		"A@name(1",
-		"L@env",
 		"L@env:",
+		"L@env~",
+		"L@env~a[",
+		"L@mem>=",
+		"L@mem>=large",
+		"C@",
+		"C@a[",
+		"P@",
+		"P@http",
+		"N@",
+		"N@10.0.0.0/40",
+		"N@host",
+		"G@",
 		"L@:prd",
 		"L@:",
 		"L@env:key:val",
@@ -55,3 +95,59 @@ func TestInvalidFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestSyntaxError(t *testing.T) {
+	testcase := []struct {
+		expr     string
+		offset   int
+		expected string
+	}{
+		{"name1", 0, `"(", "not", "A@", "L@", "C@", "P@", "N@", "G@"`},
+		{"A@x and", 7, "space"},
+		{"A@x and ", 8, `"(", "not", "A@", "L@", "C@", "P@", "N@", "G@"`},
+		{"(A@x", 4, `"and", "or", ")"`},
+		{"A@x)", 3, `"and", "or", end of input`},
+		{"L@env:", 6, "value"},
+		{"L@mem>=large", 7, "number"},
+		{"notA@x", 3, "space"},
+		{"A@name[1", 2, ""},
+		{"L@env~a[", 6, ""},
+	}
+	for _, c := range testcase {
+		_, err := Parse(c.expr)
+		e, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("expr %s expected syntax error, but %v", c.expr, err)
+		}
+		if e.Offset != c.offset {
+			t.Fatalf("expr %s expected offset %d, but %d", c.expr, c.offset, e.Offset)
+		}
+		if s := strings.Join(e.Expected, ", "); s != c.expected {
+			t.Fatalf("expr %s expected %s, but %s", c.expr, c.expected, s)
+		}
+	}
+
+	e := &SyntaxError{Expr: "A@x)", Offset: 3}
+	if s := e.Caret(); s != "A@x)\n   ^" {
+		t.Fatalf("unexpected caret: %q", s)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	e, err := Parse("L@zone:a or (C@^redis and not P@80)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cap := &rpc.Capability{Labels: map[string]string{"zone": "b"}, UsedNames: []string{"redis-1"}}
+	expected := []string{
+		"or: true",
+		"  L@zone:a: false (zone=b)",
+		"  and: true",
+		"    C@^redis: true (container redis-1)",
+		"    not: true",
+		"      P@80: false",
+	}
+	if lines := Explain(e, cap); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %q, but %q", expected, lines)
+	}
+}
@@ -22,6 +22,13 @@ const (
 	ruleprimary
 	ruleagent
 	rulelabel
+	rulecontainer
+	ruleport
+	rulenetwork
+	rulegroup
+	rulelname
+	rulelcond
+	rulenumber
 	ruleregexp
 	rulechar
 	rulews
@@ -32,6 +39,10 @@ const (
 	rulePegText
 	ruleAction3
 	ruleAction4
+	ruleAction5
+	ruleAction6
+	ruleAction7
+	ruleAction8
 
 	rulePre_
 	rule_In_
@@ -48,6 +59,13 @@ var rul3s = [...]string{
 	"primary",
 	"agent",
 	"label",
+	"container",
+	"port",
+	"network",
+	"group",
+	"lname",
+	"lcond",
+	"number",
 	"regexp",
 	"char",
 	"ws",
@@ -58,6 +76,10 @@ var rul3s = [...]string{
 	"PegText",
 	"Action3",
 	"Action4",
+	"Action5",
+	"Action6",
+	"Action7",
+	"Action8",
 
 	"Pre_",
 	"_In_",
@@ -627,7 +649,7 @@ type parser struct {
 
 	Buffer string
 	buffer []rune
-	rules  [19]func() bool
+	rules  [30]func() bool
 	Parse  func(rule ...int) error
 	Reset  func()
 	tokenTree
@@ -711,9 +733,17 @@ func (p *parser) Execute() {
 		case ruleAction2:
 			p.Not()
 		case ruleAction3:
-			p.Agent(buffer[begin:end])
+			p.Agent(begin, buffer[begin:end])
 		case ruleAction4:
-			p.Label(buffer[begin:end])
+			p.Label(begin, buffer[begin:end])
+		case ruleAction5:
+			p.Container(begin, buffer[begin:end])
+		case ruleAction6:
+			p.Port(begin, buffer[begin:end])
+		case ruleAction7:
+			p.Network(begin, buffer[begin:end])
+		case ruleAction8:
+			p.Group(begin, buffer[begin:end])
 
 		}
 	}
@@ -875,501 +905,973 @@ func (p *parser) Init() {
 		nil,
 		/* 3 factor <- <(primary andExpr*)> */
 		func() bool {
-			position14, tokenIndex14, depth14 := position, tokenIndex, depth
+			position12, tokenIndex12, depth12 := position, tokenIndex, depth
 			{
-				position15 := position
+				position13 := position
 				depth++
 				if !_rules[ruleprimary]() {
-					goto l14
+					goto l12
 				}
-			l16:
+			l14:
 				{
-					position17, tokenIndex17, depth17 := position, tokenIndex, depth
+					position15, tokenIndex15, depth15 := position, tokenIndex, depth
 					{
-						position18 := position
+						position16 := position
 						depth++
 						if !_rules[rulewsp]() {
+							goto l15
+						}
+						{
+							position17, tokenIndex17, depth17 := position, tokenIndex, depth
+							if buffer[position] != rune('a') {
+								goto l18
+							}
+							position++
 							goto l17
+						l18:
+							position, tokenIndex, depth = position17, tokenIndex17, depth17
+							if buffer[position] != rune('A') {
+								goto l15
+							}
+							position++
 						}
+					l17:
 						{
 							position19, tokenIndex19, depth19 := position, tokenIndex, depth
-							if buffer[position] != rune('a') {
+							if buffer[position] != rune('n') {
 								goto l20
 							}
 							position++
 							goto l19
 						l20:
 							position, tokenIndex, depth = position19, tokenIndex19, depth19
-							if buffer[position] != rune('A') {
-								goto l17
+							if buffer[position] != rune('N') {
+								goto l15
 							}
 							position++
 						}
 					l19:
 						{
 							position21, tokenIndex21, depth21 := position, tokenIndex, depth
-							if buffer[position] != rune('n') {
+							if buffer[position] != rune('d') {
 								goto l22
 							}
 							position++
 							goto l21
 						l22:
 							position, tokenIndex, depth = position21, tokenIndex21, depth21
-							if buffer[position] != rune('N') {
-								goto l17
-							}
-							position++
-						}
-					l21:
-						{
-							position23, tokenIndex23, depth23 := position, tokenIndex, depth
-							if buffer[position] != rune('d') {
-								goto l24
-							}
-							position++
-							goto l23
-						l24:
-							position, tokenIndex, depth = position23, tokenIndex23, depth23
 							if buffer[position] != rune('D') {
-								goto l17
+								goto l15
 							}
 							position++
 						}
-					l23:
+					l21:
 						if !_rules[rulewsp]() {
-							goto l17
+							goto l15
 						}
 						if !_rules[ruleprimary]() {
-							goto l17
+							goto l15
 						}
 						{
 							add(ruleAction1, position)
 						}
 						depth--
-						add(ruleandExpr, position18)
+						add(ruleandExpr, position16)
 					}
-					goto l16
-				l17:
-					position, tokenIndex, depth = position17, tokenIndex17, depth17
+					goto l14
+				l15:
+					position, tokenIndex, depth = position15, tokenIndex15, depth15
 				}
 				depth--
-				add(rulefactor, position15)
+				add(rulefactor, position13)
 			}
 			return true
-		l14:
-			position, tokenIndex, depth = position14, tokenIndex14, depth14
+		l12:
+			position, tokenIndex, depth = position12, tokenIndex12, depth12
 			return false
 		},
 		/* 4 andExpr <- <(wsp (('a' / 'A') ('n' / 'N') ('d' / 'D')) wsp primary Action1)> */
 		nil,
-		/* 5 primary <- <((&('L' | 'l') label) | (&('A' | 'a') agent) | (&('(') ('(' ws Expr ws ')')) | (&('N' | 'n') (('n' / 'N') ('o' / 'O') ('t' / 'T') wsp primary Action2)))> */
+		/* 5 primary <- <(('(' ws Expr ws ')') / (('n' / 'N') ('o' / 'O') ('t' / 'T') wsp primary Action2) / agent / label / container / port / network / group)> */
 		func() bool {
-			position27, tokenIndex27, depth27 := position, tokenIndex, depth
+			position23, tokenIndex23, depth23 := position, tokenIndex, depth
 			{
-				position28 := position
+				position24 := position
 				depth++
 				{
-					switch buffer[position] {
-					case 'L', 'l':
+					position25, tokenIndex25, depth25 := position, tokenIndex, depth
+					if buffer[position] != rune('(') {
+						goto l26
+					}
+					position++
+					if !_rules[rulews]() {
+						goto l26
+					}
+					if !_rules[ruleExpr]() {
+						goto l26
+					}
+					if !_rules[rulews]() {
+						goto l26
+					}
+					if buffer[position] != rune(')') {
+						goto l26
+					}
+					position++
+					goto l25
+				l26:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position28, tokenIndex28, depth28 := position, tokenIndex, depth
+						if buffer[position] != rune('n') {
+							goto l29
+						}
+						position++
+						goto l28
+					l29:
+						position, tokenIndex, depth = position28, tokenIndex28, depth28
+						if buffer[position] != rune('N') {
+							goto l27
+						}
+						position++
+					}
+				l28:
+					{
+						position30, tokenIndex30, depth30 := position, tokenIndex, depth
+						if buffer[position] != rune('o') {
+							goto l31
+						}
+						position++
+						goto l30
+					l31:
+						position, tokenIndex, depth = position30, tokenIndex30, depth30
+						if buffer[position] != rune('O') {
+							goto l27
+						}
+						position++
+					}
+				l30:
+					{
+						position32, tokenIndex32, depth32 := position, tokenIndex, depth
+						if buffer[position] != rune('t') {
+							goto l33
+						}
+						position++
+						goto l32
+					l33:
+						position, tokenIndex, depth = position32, tokenIndex32, depth32
+						if buffer[position] != rune('T') {
+							goto l27
+						}
+						position++
+					}
+				l32:
+					if !_rules[rulewsp]() {
+						goto l27
+					}
+					if !_rules[ruleprimary]() {
+						goto l27
+					}
+					{
+						add(ruleAction2, position)
+					}
+					goto l25
+				l27:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position35 := position
+						depth++
 						{
-							position30 := position
+							position36, tokenIndex36, depth36 := position, tokenIndex, depth
+							if buffer[position] != rune('a') {
+								goto l37
+							}
+							position++
+							goto l36
+						l37:
+							position, tokenIndex, depth = position36, tokenIndex36, depth36
+							if buffer[position] != rune('A') {
+								goto l34
+							}
+							position++
+						}
+					l36:
+						if buffer[position] != rune('@') {
+							goto l34
+						}
+						position++
+						{
+							position38 := position
 							depth++
-							{
-								position31, tokenIndex31, depth31 := position, tokenIndex, depth
-								if buffer[position] != rune('l') {
-									goto l32
-								}
-								position++
-								goto l31
-							l32:
-								position, tokenIndex, depth = position31, tokenIndex31, depth31
-								if buffer[position] != rune('L') {
-									goto l27
-								}
-								position++
+							if !_rules[ruleregexp]() {
+								goto l34
 							}
-						l31:
-							if buffer[position] != rune('@') {
-								goto l27
+							depth--
+							add(rulePegText, position38)
+						}
+						{
+							add(ruleAction3, position)
+						}
+						depth--
+						add(ruleagent, position35)
+					}
+					goto l25
+				l34:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position40 := position
+						depth++
+						{
+							position41, tokenIndex41, depth41 := position, tokenIndex, depth
+							if buffer[position] != rune('l') {
+								goto l42
+							}
+							position++
+							goto l41
+						l42:
+							position, tokenIndex, depth = position41, tokenIndex41, depth41
+							if buffer[position] != rune('L') {
+								goto l39
 							}
 							position++
+						}
+					l41:
+						if buffer[position] != rune('@') {
+							goto l39
+						}
+						position++
+						{
+							position43 := position
+							depth++
 							{
-								position33 := position
+								position44 := position
 								depth++
-								if !_rules[rulechar]() {
-									goto l27
-								}
-							l34:
 								{
-									position35, tokenIndex35, depth35 := position, tokenIndex, depth
-									if !_rules[rulechar]() {
-										goto l35
+									position45, tokenIndex45, depth45 := position, tokenIndex, depth
+									{
+										switch buffer[position] {
+										case '>':
+											if buffer[position] != rune('>') {
+												goto l45
+											}
+											position++
+											break
+										case '<':
+											if buffer[position] != rune('<') {
+												goto l45
+											}
+											position++
+											break
+										default:
+											if buffer[position] != rune('~') {
+												goto l45
+											}
+											position++
+											break
+										}
 									}
-									goto l34
-								l35:
-									position, tokenIndex, depth = position35, tokenIndex35, depth35
-								}
-								if buffer[position] != rune(':') {
-									goto l27
+
+									goto l39
+								l45:
+									position, tokenIndex, depth = position45, tokenIndex45, depth45
 								}
-								position++
 								if !_rules[rulechar]() {
-									goto l27
+									goto l39
 								}
-							l36:
+							l46:
 								{
-									position37, tokenIndex37, depth37 := position, tokenIndex, depth
+									position47, tokenIndex47, depth47 := position, tokenIndex, depth
+									{
+										position48, tokenIndex48, depth48 := position, tokenIndex, depth
+										{
+											switch buffer[position] {
+											case '>':
+												if buffer[position] != rune('>') {
+													goto l48
+												}
+												position++
+												break
+											case '<':
+												if buffer[position] != rune('<') {
+													goto l48
+												}
+												position++
+												break
+											default:
+												if buffer[position] != rune('~') {
+													goto l48
+												}
+												position++
+												break
+											}
+										}
+
+										goto l47
+									l48:
+										position, tokenIndex, depth = position48, tokenIndex48, depth48
+									}
 									if !_rules[rulechar]() {
-										goto l37
+										goto l47
 									}
-									goto l36
-								l37:
-									position, tokenIndex, depth = position37, tokenIndex37, depth37
+									goto l46
+								l47:
+									position, tokenIndex, depth = position47, tokenIndex47, depth47
 								}
 								depth--
-								add(rulePegText, position33)
+								add(rulelname, position44)
 							}
 							{
-								add(ruleAction4, position)
+								position49, tokenIndex49, depth49 := position, tokenIndex, depth
+								{
+									position51 := position
+									depth++
+									{
+										switch buffer[position] {
+										case ':':
+											if buffer[position] != rune(':') {
+												goto l50
+											}
+											position++
+											if !_rules[rulechar]() {
+												goto l50
+											}
+										l52:
+											{
+												position53, tokenIndex53, depth53 := position, tokenIndex, depth
+												if !_rules[rulechar]() {
+													goto l53
+												}
+												goto l52
+											l53:
+												position, tokenIndex, depth = position53, tokenIndex53, depth53
+											}
+											break
+										case '~':
+											if buffer[position] != rune('~') {
+												goto l50
+											}
+											position++
+											if !_rules[ruleregexp]() {
+												goto l50
+											}
+											break
+										default:
+											{
+												switch buffer[position] {
+												case '>':
+													if buffer[position] != rune('>') {
+														goto l50
+													}
+													position++
+													break
+												default:
+													if buffer[position] != rune('<') {
+														goto l50
+													}
+													position++
+													break
+												}
+											}
+
+											{
+												position54, tokenIndex54, depth54 := position, tokenIndex, depth
+												if buffer[position] != rune('=') {
+													goto l55
+												}
+												position++
+												goto l54
+											l55:
+												position, tokenIndex, depth = position54, tokenIndex54, depth54
+											}
+										l54:
+											{
+												position56 := position
+												depth++
+												{
+													position57, tokenIndex57, depth57 := position, tokenIndex, depth
+													if buffer[position] != rune('-') {
+														goto l58
+													}
+													position++
+													goto l57
+												l58:
+													position, tokenIndex, depth = position57, tokenIndex57, depth57
+												}
+											l57:
+												if c := buffer[position]; c < rune('0') || c > rune('9') {
+													goto l50
+												}
+												position++
+											l59:
+												{
+													position60, tokenIndex60, depth60 := position, tokenIndex, depth
+													if c := buffer[position]; c < rune('0') || c > rune('9') {
+														goto l60
+													}
+													position++
+													goto l59
+												l60:
+													position, tokenIndex, depth = position60, tokenIndex60, depth60
+												}
+												{
+													position61, tokenIndex61, depth61 := position, tokenIndex, depth
+													if buffer[position] != rune('.') {
+														goto l62
+													}
+													position++
+													if c := buffer[position]; c < rune('0') || c > rune('9') {
+														goto l62
+													}
+													position++
+												l63:
+													{
+														position64, tokenIndex64, depth64 := position, tokenIndex, depth
+														if c := buffer[position]; c < rune('0') || c > rune('9') {
+															goto l64
+														}
+														position++
+														goto l63
+													l64:
+														position, tokenIndex, depth = position64, tokenIndex64, depth64
+													}
+													goto l61
+												l62:
+													position, tokenIndex, depth = position61, tokenIndex61, depth61
+												}
+											l61:
+												depth--
+												add(rulenumber, position56)
+											}
+											break
+										}
+									}
+
+									depth--
+									add(rulelcond, position51)
+								}
+								goto l49
+							l50:
+								position, tokenIndex, depth = position49, tokenIndex49, depth49
 							}
+						l49:
 							depth--
-							add(rulelabel, position30)
+							add(rulePegText, position43)
 						}
-						break
-					case 'A', 'a':
 						{
-							position39 := position
-							depth++
-							{
-								position40, tokenIndex40, depth40 := position, tokenIndex, depth
-								if buffer[position] != rune('a') {
-									goto l41
-								}
-								position++
-								goto l40
-							l41:
-								position, tokenIndex, depth = position40, tokenIndex40, depth40
-								if buffer[position] != rune('A') {
-									goto l27
-								}
-								position++
-							}
-						l40:
-							if buffer[position] != rune('@') {
-								goto l27
+							add(ruleAction4, position)
+						}
+						depth--
+						add(rulelabel, position40)
+					}
+					goto l25
+				l39:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position66 := position
+						depth++
+						{
+							position67, tokenIndex67, depth67 := position, tokenIndex, depth
+							if buffer[position] != rune('c') {
+								goto l68
 							}
 							position++
-							{
-								position42 := position
-								depth++
-								if !_rules[ruleregexp]() {
-									goto l27
-								}
-								depth--
-								add(rulePegText, position42)
-							}
-							{
-								add(ruleAction3, position)
+							goto l67
+						l68:
+							position, tokenIndex, depth = position67, tokenIndex67, depth67
+							if buffer[position] != rune('C') {
+								goto l65
 							}
-							depth--
-							add(ruleagent, position39)
+							position++
 						}
-						break
-					case '(':
-						if buffer[position] != rune('(') {
-							goto l27
+					l67:
+						if buffer[position] != rune('@') {
+							goto l65
 						}
 						position++
-						if !_rules[rulews]() {
-							goto l27
+						{
+							position69 := position
+							depth++
+							if !_rules[ruleregexp]() {
+								goto l65
+							}
+							depth--
+							add(rulePegText, position69)
 						}
-						if !_rules[ruleExpr]() {
-							goto l27
+						{
+							add(ruleAction5, position)
 						}
-						if !_rules[rulews]() {
-							goto l27
+						depth--
+						add(rulecontainer, position66)
+					}
+					goto l25
+				l65:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position71 := position
+						depth++
+						{
+							position72, tokenIndex72, depth72 := position, tokenIndex, depth
+							if buffer[position] != rune('p') {
+								goto l73
+							}
+							position++
+							goto l72
+						l73:
+							position, tokenIndex, depth = position72, tokenIndex72, depth72
+							if buffer[position] != rune('P') {
+								goto l70
+							}
+							position++
 						}
-						if buffer[position] != rune(')') {
-							goto l27
+					l72:
+						if buffer[position] != rune('@') {
+							goto l70
 						}
 						position++
-						break
-					default:
 						{
-							position44, tokenIndex44, depth44 := position, tokenIndex, depth
+							position74 := position
+							depth++
+							if c := buffer[position]; c < rune('0') || c > rune('9') {
+								goto l70
+							}
+							position++
+						l75:
+							{
+								position76, tokenIndex76, depth76 := position, tokenIndex, depth
+								if c := buffer[position]; c < rune('0') || c > rune('9') {
+									goto l76
+								}
+								position++
+								goto l75
+							l76:
+								position, tokenIndex, depth = position76, tokenIndex76, depth76
+							}
+							depth--
+							add(rulePegText, position74)
+						}
+						{
+							add(ruleAction6, position)
+						}
+						depth--
+						add(ruleport, position71)
+					}
+					goto l25
+				l70:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position78 := position
+						depth++
+						{
+							position79, tokenIndex79, depth79 := position, tokenIndex, depth
 							if buffer[position] != rune('n') {
-								goto l45
+								goto l80
 							}
 							position++
-							goto l44
-						l45:
-							position, tokenIndex, depth = position44, tokenIndex44, depth44
+							goto l79
+						l80:
+							position, tokenIndex, depth = position79, tokenIndex79, depth79
 							if buffer[position] != rune('N') {
-								goto l27
+								goto l77
 							}
 							position++
 						}
-					l44:
+					l79:
+						if buffer[position] != rune('@') {
+							goto l77
+						}
+						position++
 						{
-							position46, tokenIndex46, depth46 := position, tokenIndex, depth
-							if buffer[position] != rune('o') {
-								goto l47
+							position81 := position
+							depth++
+							{
+								switch buffer[position] {
+								case '/':
+									if buffer[position] != rune('/') {
+										goto l77
+									}
+									position++
+									break
+								case ':':
+									if buffer[position] != rune(':') {
+										goto l77
+									}
+									position++
+									break
+								case '.':
+									if buffer[position] != rune('.') {
+										goto l77
+									}
+									position++
+									break
+								case 'A', 'B', 'C', 'D', 'E', 'F':
+									if c := buffer[position]; c < rune('A') || c > rune('F') {
+										goto l77
+									}
+									position++
+									break
+								case 'a', 'b', 'c', 'd', 'e', 'f':
+									if c := buffer[position]; c < rune('a') || c > rune('f') {
+										goto l77
+									}
+									position++
+									break
+								default:
+									if c := buffer[position]; c < rune('0') || c > rune('9') {
+										goto l77
+									}
+									position++
+									break
+								}
 							}
-							position++
-							goto l46
-						l47:
-							position, tokenIndex, depth = position46, tokenIndex46, depth46
-							if buffer[position] != rune('O') {
-								goto l27
+
+						l82:
+							{
+								position83, tokenIndex83, depth83 := position, tokenIndex, depth
+								{
+									switch buffer[position] {
+									case '/':
+										if buffer[position] != rune('/') {
+											goto l83
+										}
+										position++
+										break
+									case ':':
+										if buffer[position] != rune(':') {
+											goto l83
+										}
+										position++
+										break
+									case '.':
+										if buffer[position] != rune('.') {
+											goto l83
+										}
+										position++
+										break
+									case 'A', 'B', 'C', 'D', 'E', 'F':
+										if c := buffer[position]; c < rune('A') || c > rune('F') {
+											goto l83
+										}
+										position++
+										break
+									case 'a', 'b', 'c', 'd', 'e', 'f':
+										if c := buffer[position]; c < rune('a') || c > rune('f') {
+											goto l83
+										}
+										position++
+										break
+									default:
+										if c := buffer[position]; c < rune('0') || c > rune('9') {
+											goto l83
+										}
+										position++
+										break
+									}
+								}
+
+								goto l82
+							l83:
+								position, tokenIndex, depth = position83, tokenIndex83, depth83
 							}
-							position++
+							depth--
+							add(rulePegText, position81)
 						}
-					l46:
 						{
-							position48, tokenIndex48, depth48 := position, tokenIndex, depth
-							if buffer[position] != rune('t') {
-								goto l49
+							add(ruleAction7, position)
+						}
+						depth--
+						add(rulenetwork, position78)
+					}
+					goto l25
+				l77:
+					position, tokenIndex, depth = position25, tokenIndex25, depth25
+					{
+						position84 := position
+						depth++
+						{
+							position85, tokenIndex85, depth85 := position, tokenIndex, depth
+							if buffer[position] != rune('g') {
+								goto l86
 							}
 							position++
-							goto l48
-						l49:
-							position, tokenIndex, depth = position48, tokenIndex48, depth48
-							if buffer[position] != rune('T') {
-								goto l27
+							goto l85
+						l86:
+							position, tokenIndex, depth = position85, tokenIndex85, depth85
+							if buffer[position] != rune('G') {
+								goto l23
 							}
 							position++
 						}
-					l48:
-						if !_rules[rulewsp]() {
-							goto l27
+					l85:
+						if buffer[position] != rune('@') {
+							goto l23
 						}
-						if !_rules[ruleprimary]() {
-							goto l27
+						position++
+						{
+							position87 := position
+							depth++
+							if !_rules[rulechar]() {
+								goto l23
+							}
+						l88:
+							{
+								position89, tokenIndex89, depth89 := position, tokenIndex, depth
+								if !_rules[rulechar]() {
+									goto l89
+								}
+								goto l88
+							l89:
+								position, tokenIndex, depth = position89, tokenIndex89, depth89
+							}
+							depth--
+							add(rulePegText, position87)
 						}
 						{
-							add(ruleAction2, position)
+							add(ruleAction8, position)
 						}
-						break
+						depth--
+						add(rulegroup, position84)
 					}
 				}
-
+			l25:
 				depth--
-				add(ruleprimary, position28)
+				add(ruleprimary, position24)
 			}
 			return true
-		l27:
-			position, tokenIndex, depth = position27, tokenIndex27, depth27
+		l23:
+			position, tokenIndex, depth = position23, tokenIndex23, depth23
 			return false
 		},
 		/* 6 agent <- <(('a' / 'A') '@' <regexp> Action3)> */
 		nil,
-		/* 7 label <- <(('l' / 'L') '@' <(char+ ':' char+)> Action4)> */
+		/* 7 label <- <(('l' / 'L') '@' <(lname lcond?)> Action4)> */
+		nil,
+		/* 8 container <- <(('c' / 'C') '@' <regexp> Action5)> */
+		nil,
+		/* 9 port <- <(('p' / 'P') '@' <[0-9]+> Action6)> */
+		nil,
+		/* 10 network <- <(('n' / 'N') '@' <((&('/') '/') | (&(':') ':') | (&('.') '.') | (&('A' | 'B' | 'C' | 'D' | 'E' | 'F') [A-F]) | (&('a' | 'b' | 'c' | 'd' | 'e' | 'f') [a-f]) | (&('0' | '1' | '2' | '3' | '4' | '5' | '6' | '7' | '8' | '9') [0-9]))+> Action7)> */
+		nil,
+		/* 11 group <- <(('g' / 'G') '@' <char+> Action8)> */
+		nil,
+		/* 12 lname <- <(!((&('>') '>') | (&('<') '<') | (&('~') '~')) char)+> */
 		nil,
-		/* 8 regexp <- <((char* '(' regexp ')' char*) / (char* '|' regexp*) / char+)> */
+		/* 13 lcond <- <((&(':') (':' char+)) | (&('~') ('~' regexp)) | (&('<' | '>') (((&('>') '>') | (&('<') '<')) '='? number)))> */
+		nil,
+		/* 14 number <- <('-'? [0-9]+ ('.' [0-9]+)?)> */
+		nil,
+		/* 15 regexp <- <((char* '(' regexp ')' char*) / (char* '|' regexp*) / char+)> */
 		func() bool {
-			position53, tokenIndex53, depth53 := position, tokenIndex, depth
+			position90, tokenIndex90, depth90 := position, tokenIndex, depth
 			{
-				position54 := position
+				position91 := position
 				depth++
 				{
-					position55, tokenIndex55, depth55 := position, tokenIndex, depth
-				l57:
+					position92, tokenIndex92, depth92 := position, tokenIndex, depth
+				l94:
 					{
-						position58, tokenIndex58, depth58 := position, tokenIndex, depth
+						position95, tokenIndex95, depth95 := position, tokenIndex, depth
 						if !_rules[rulechar]() {
-							goto l58
+							goto l95
 						}
-						goto l57
-					l58:
-						position, tokenIndex, depth = position58, tokenIndex58, depth58
+						goto l94
+					l95:
+						position, tokenIndex, depth = position95, tokenIndex95, depth95
 					}
 					if buffer[position] != rune('(') {
-						goto l56
+						goto l93
 					}
 					position++
 					if !_rules[ruleregexp]() {
-						goto l56
+						goto l93
 					}
 					if buffer[position] != rune(')') {
-						goto l56
+						goto l93
 					}
 					position++
-				l59:
+				l96:
 					{
-						position60, tokenIndex60, depth60 := position, tokenIndex, depth
+						position97, tokenIndex97, depth97 := position, tokenIndex, depth
 						if !_rules[rulechar]() {
-							goto l60
+							goto l97
 						}
-						goto l59
-					l60:
-						position, tokenIndex, depth = position60, tokenIndex60, depth60
+						goto l96
+					l97:
+						position, tokenIndex, depth = position97, tokenIndex97, depth97
 					}
-					goto l55
-				l56:
-					position, tokenIndex, depth = position55, tokenIndex55, depth55
-				l62:
+					goto l92
+				l93:
+					position, tokenIndex, depth = position92, tokenIndex92, depth92
+				l99:
 					{
-						position63, tokenIndex63, depth63 := position, tokenIndex, depth
+						position100, tokenIndex100, depth100 := position, tokenIndex, depth
 						if !_rules[rulechar]() {
-							goto l63
+							goto l100
 						}
-						goto l62
-					l63:
-						position, tokenIndex, depth = position63, tokenIndex63, depth63
+						goto l99
+					l100:
+						position, tokenIndex, depth = position100, tokenIndex100, depth100
 					}
 					if buffer[position] != rune('|') {
-						goto l61
+						goto l98
 					}
 					position++
-				l64:
+				l101:
 					{
-						position65, tokenIndex65, depth65 := position, tokenIndex, depth
+						position102, tokenIndex102, depth102 := position, tokenIndex, depth
 						if !_rules[ruleregexp]() {
-							goto l65
+							goto l102
 						}
-						goto l64
-					l65:
-						position, tokenIndex, depth = position65, tokenIndex65, depth65
+						goto l101
+					l102:
+						position, tokenIndex, depth = position102, tokenIndex102, depth102
 					}
-					goto l55
-				l61:
-					position, tokenIndex, depth = position55, tokenIndex55, depth55
+					goto l92
+				l98:
+					position, tokenIndex, depth = position92, tokenIndex92, depth92
 					if !_rules[rulechar]() {
-						goto l53
+						goto l90
 					}
-				l66:
+				l103:
 					{
-						position67, tokenIndex67, depth67 := position, tokenIndex, depth
+						position104, tokenIndex104, depth104 := position, tokenIndex, depth
 						if !_rules[rulechar]() {
-							goto l67
+							goto l104
 						}
-						goto l66
-					l67:
-						position, tokenIndex, depth = position67, tokenIndex67, depth67
+						goto l103
+					l104:
+						position, tokenIndex, depth = position104, tokenIndex104, depth104
 					}
 				}
-			l55:
+			l92:
 				depth--
-				add(ruleregexp, position54)
+				add(ruleregexp, position91)
 			}
 			return true
-		l53:
-			position, tokenIndex, depth = position53, tokenIndex53, depth53
+		l90:
+			position, tokenIndex, depth = position90, tokenIndex90, depth90
 			return false
 		},
-		/* 9 char <- <(!((&(':') ':') | (&(')') ')') | (&('(') '(') | (&(' ') ' ')) .)> */
+		/* 16 char <- <(!((&(':') ':') | (&(')') ')') | (&('(') '(') | (&(' ') ' ')) .)> */
 		func() bool {
-			position68, tokenIndex68, depth68 := position, tokenIndex, depth
+			position105, tokenIndex105, depth105 := position, tokenIndex, depth
 			{
-				position69 := position
+				position106 := position
 				depth++
 				{
-					position70, tokenIndex70, depth70 := position, tokenIndex, depth
+					position107, tokenIndex107, depth107 := position, tokenIndex, depth
 					{
 						switch buffer[position] {
 						case ':':
 							if buffer[position] != rune(':') {
-								goto l70
+								goto l107
 							}
 							position++
 							break
 						case ')':
 							if buffer[position] != rune(')') {
-								goto l70
+								goto l107
 							}
 							position++
 							break
 						case '(':
 							if buffer[position] != rune('(') {
-								goto l70
+								goto l107
 							}
 							position++
 							break
 						default:
 							if buffer[position] != rune(' ') {
-								goto l70
+								goto l107
 							}
 							position++
 							break
 						}
 					}
 
-					goto l68
-				l70:
-					position, tokenIndex, depth = position70, tokenIndex70, depth70
+					goto l105
+				l107:
+					position, tokenIndex, depth = position107, tokenIndex107, depth107
 				}
 				if !matchDot() {
-					goto l68
+					goto l105
 				}
 				depth--
-				add(rulechar, position69)
+				add(rulechar, position106)
 			}
 			return true
-		l68:
-			position, tokenIndex, depth = position68, tokenIndex68, depth68
+		l105:
+			position, tokenIndex, depth = position105, tokenIndex105, depth105
 			return false
 		},
-		/* 10 ws <- <' '*> */
+		/* 17 ws <- <' '*> */
 		func() bool {
 			{
-				position73 := position
+				position109 := position
 				depth++
-			l74:
+			l110:
 				{
-					position75, tokenIndex75, depth75 := position, tokenIndex, depth
+					position111, tokenIndex111, depth111 := position, tokenIndex, depth
 					if buffer[position] != rune(' ') {
-						goto l75
+						goto l111
 					}
 					position++
-					goto l74
-				l75:
-					position, tokenIndex, depth = position75, tokenIndex75, depth75
+					goto l110
+				l111:
+					position, tokenIndex, depth = position111, tokenIndex111, depth111
 				}
 				depth--
-				add(rulews, position73)
+				add(rulews, position109)
 			}
 			return true
 		},
-		/* 11 wsp <- <' '+> */
+		/* 18 wsp <- <' '+> */
 		func() bool {
-			position76, tokenIndex76, depth76 := position, tokenIndex, depth
+			position112, tokenIndex112, depth112 := position, tokenIndex, depth
 			{
-				position77 := position
+				position113 := position
 				depth++
 				if buffer[position] != rune(' ') {
-					goto l76
+					goto l112
 				}
 				position++
-			l78:
+			l114:
 				{
-					position79, tokenIndex79, depth79 := position, tokenIndex, depth
+					position115, tokenIndex115, depth115 := position, tokenIndex, depth
 					if buffer[position] != rune(' ') {
-						goto l79
+						goto l115
 					}
 					position++
-					goto l78
-				l79:
-					position, tokenIndex, depth = position79, tokenIndex79, depth79
+					goto l114
+				l115:
+					position, tokenIndex, depth = position115, tokenIndex115, depth115
 				}
 				depth--
-				add(rulewsp, position77)
+				add(rulewsp, position113)
 			}
 			return true
-		l76:
-			position, tokenIndex, depth = position76, tokenIndex76, depth76
+		l112:
+			position, tokenIndex, depth = position112, tokenIndex112, depth112
 			return false
 		},
-		/* 13 Action0 <- <{ p.Or() }> */
+		/* 20 Action0 <- <{ p.Or() }> */
+		nil,
+		/* 21 Action1 <- <{ p.And() }> */
+		nil,
+		/* 22 Action2 <- <{ p.Not() }> */
 		nil,
-		/* 14 Action1 <- <{ p.And() }> */
 		nil,
-		/* 15 Action2 <- <{ p.Not() }> */
+		/* 24 Action3 <- <{ p.Agent(begin, buffer[begin:end]) }> */
 		nil,
+		/* 25 Action4 <- <{ p.Label(begin, buffer[begin:end]) }> */
 		nil,
-		/* 17 Action3 <- <{ p.Agent(buffer[begin:end]) }> */
+		/* 26 Action5 <- <{ p.Container(begin, buffer[begin:end]) }> */
 		nil,
-		/* 18 Action4 <- <{ p.Label(buffer[begin:end]) }> */
+		/* 27 Action6 <- <{ p.Port(begin, buffer[begin:end]) }> */
+		nil,
+		/* 28 Action7 <- <{ p.Network(begin, buffer[begin:end]) }> */
+		nil,
+		/* 29 Action8 <- <{ p.Group(begin, buffer[begin:end]) }> */
 		nil,
 	}
+
 	p.rules = _rules
 }
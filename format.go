@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// FormatOptions is embedded by listing commands to print records in a
+// machine-readable format instead of human-readable tables.
+type FormatOptions struct {
+	Format string `long:"format" description:"Output format: json, jsonl or Go template (e.g. '{{.Agent}} {{.ID}}')"`
+}
+
+func (opts *FormatOptions) Formatted() bool {
+	return opts.Format != ""
+}
+
+// WriteRecords writes records, which must be a slice, using the format.
+func (opts *FormatOptions) WriteRecords(w io.Writer, records interface{}) error {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("records must be a slice: %s", v.Kind())
+	}
+
+	switch opts.Format {
+	case "json":
+		if v.Len() == 0 {
+			// encode as an empty array instead of null
+			records = []struct{}{}
+		}
+		enc := json.NewEncoder(w)
+		return enc.Encode(records)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for i := 0; i < v.Len(); i++ {
+			if err := enc.Encode(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !strings.Contains(opts.Format, "{{") {
+		// likely a typo of json or jsonl, not a template printing itself
+		return fmt.Errorf("Unknown format: %s", opts.Format)
+	}
+	tmpl, err := template.New("format").Parse(opts.Format)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < v.Len(); i++ {
+		if err := tmpl.Execute(w, v.Index(i).Interface()); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
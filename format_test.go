@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testRecord struct {
+	Agent string
+	ID    string
+}
+
+func TestWriteRecords(t *testing.T) {
+	records := []testRecord{{"agent1", "a1"}, {"agent2", "b2"}}
+	data := []struct {
+		format   string
+		records  interface{}
+		expected string
+	}{
+		{"json", records, `[{"Agent":"agent1","ID":"a1"},{"Agent":"agent2","ID":"b2"}]` + "\n"},
+		{"json", []testRecord(nil), "[]\n"},
+		{"jsonl", records, `{"Agent":"agent1","ID":"a1"}` + "\n" + `{"Agent":"agent2","ID":"b2"}` + "\n"},
+		{"jsonl", []testRecord{}, ""},
+		{"{{.Agent}} {{.ID}}", records, "agent1 a1\nagent2 b2\n"},
+	}
+	for _, test := range data {
+		var buf bytes.Buffer
+		opts := &FormatOptions{Format: test.format}
+		if err := opts.WriteRecords(&buf, test.records); err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if s := buf.String(); s != test.expected {
+			t.Errorf("%s: expected %q, but %q", test.format, test.expected, s)
+		}
+	}
+}
+
+func TestWriteRecordsError(t *testing.T) {
+	records := []testRecord{{"agent1", "a1"}}
+	for _, test := range []struct {
+		format  string
+		records interface{}
+	}{
+		{"jsno", records},
+		{"table", records},
+		{"{{.Agent", records},
+		{"{{.Unknown}}", records},
+		{"json", testRecord{}},
+	} {
+		opts := &FormatOptions{Format: test.format}
+		if err := opts.WriteRecords(&bytes.Buffer{}, test.records); err == nil {
+			t.Errorf("%s: expected an error", test.format)
+		}
+	}
+}
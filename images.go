@@ -6,15 +6,36 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
+	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/yosisa/craft/docker"
 	"github.com/yosisa/craft/rpc"
 )
 
-type CmdImages struct{}
+type CmdImages struct {
+	FormatOptions
+}
+
+type imageRecord struct {
+	Agent      string
+	Repository string
+	Tag        string
+	dockerclient.APIImages
+}
 
 func (opts *CmdImages) Execute(args []string) error {
 	images, err := rpc.ListImages(gopts.agents())
 	logRPCError(err)
+	if opts.Formatted() {
+		var records []imageRecord
+		for _, agent := range sortedKeys(images) {
+			for _, i := range images[agent].(*rpc.ListImagesResponse).Images {
+				repo, tag := splitRepoTag(i.RepoTags)
+				records = append(records, imageRecord{agent, repo, tag, i})
+			}
+		}
+		return opts.WriteRecords(os.Stdout, records)
+	}
+
 	for _, agent := range sortedKeys(images) {
 		fmt.Printf("[%s]\n", agent)
 		imgs := images[agent].(*rpc.ListImagesResponse).Images
@@ -25,12 +46,7 @@ func (opts *CmdImages) Execute(args []string) error {
 		var tw tableWriter
 		tw.Append("REPOSITORY", "TAG", "IMAGE ID", "CREATED", "VIRTUAL SIZE")
 		for _, i := range imgs {
-			var repo, tag string
-			if i.RepoTags[0] == "<none>:<none>" {
-				repo, tag = "<none>", "<none>"
-			} else {
-				repo, tag = docker.SplitImageTag(i.RepoTags[0])
-			}
+			repo, tag := splitRepoTag(i.RepoTags)
 			tw.Append(repo, tag, i.ID[:12], humanize.Time(time.Unix(i.Created, 0)),
 				humanize.Bytes(uint64(i.VirtualSize)))
 		}
@@ -40,6 +56,13 @@ func (opts *CmdImages) Execute(args []string) error {
 	return nil
 }
 
+func splitRepoTag(repoTags []string) (string, string) {
+	if len(repoTags) == 0 || repoTags[0] == "<none>:<none>" {
+		return "<none>", "<none>"
+	}
+	return docker.SplitImageTag(repoTags[0])
+}
+
 func init() {
 	parser.AddCommand("images", "List images", "", &CmdImages{})
 }
@@ -2,17 +2,23 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"hash/fnv"
 	"math/rand"
+	"net"
 	"os"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/jessevdk/go-flags"
 	"github.com/yosisa/craft/config"
+	"github.com/yosisa/craft/discovery"
 	"github.com/yosisa/craft/docker"
 	"github.com/yosisa/craft/filter"
 	"github.com/yosisa/craft/rpc"
@@ -20,9 +26,14 @@ import (
 
 type GlobalOptions struct {
 	Config string `short:"c" long:"config" description:"Configuration file"`
-	Agents string `long:"agents" description:"Comma separated agent list" env:"CRAFT_AGENTS"`
+	Agents string `long:"agents" description:"Comma separated agent list (@name for a group in the inventory)" env:"CRAFT_AGENTS"`
 	Filter string `short:"F" long:"filter" description:"Filter target agents" env:"CRAFT_FILTER"`
-	conf   *config.Config
+
+	Discover        bool          `long:"discover" description:"Add agents discovered on the network"`
+	DiscoverTimeout time.Duration `long:"discover-timeout" default:"1s" description:"How long to wait for agents to answer"`
+	Gossip          bool          `long:"gossip" description:"Add cluster members known by the agents"`
+
+	conf *config.Config
 }
 
 func (opts *GlobalOptions) ParseConfig() *config.Config {
@@ -31,22 +42,66 @@ func (opts *GlobalOptions) ParseConfig() *config.Config {
 		log.WithField("error", err).Fatal("Could not parse config file")
 	}
 	if opts.Agents != "" {
-		opts.conf.Agents = strings.Split(opts.Agents, ",")
+		opts.conf.Agents, err = opts.conf.Inventory.Expand(strings.Split(opts.Agents, ","))
+		if err != nil {
+			log.WithField("error", err).Fatal("Invalid agents")
+		}
+	}
+	if opts.Discover {
+		opts.discoverAgents()
+	}
+	if opts.Gossip {
+		opts.gossipAgents()
 	}
 	return opts.conf
 }
 
+func (opts *GlobalOptions) discoverAgents() {
+	found, err := discovery.Discover(opts.conf.Discovery, opts.DiscoverTimeout)
+	if err != nil {
+		log.WithField("error", err).Error("Failed to discover agents")
+	}
+	for _, a := range found {
+		if stringSlice(opts.conf.Agents).Contains(a.Addr) {
+			continue
+		}
+		log.WithFields(log.Fields{"agent": a.Name, "addr": a.Addr}).Debug("Agent discovered")
+		opts.conf.Agents = append(opts.conf.Agents, a.Addr)
+	}
+}
+
+func (opts *GlobalOptions) gossipAgents() {
+	members, err := rpc.Members(opts.conf.Agents)
+	if err != nil {
+		log.WithField("error", err).Error("Failed to get cluster members")
+	}
+	for _, m := range members {
+		if m.State != rpc.MemberAlive || stringSlice(opts.conf.Agents).Contains(m.Addr) {
+			continue
+		}
+		opts.conf.Agents = append(opts.conf.Agents, m.Addr)
+	}
+}
+
 func (opts *GlobalOptions) agents() []string {
 	if opts.conf == nil {
 		opts.ParseConfig()
 	}
 	v, err := filterAgents(opts.conf.Agents, opts.Filter)
 	if err != nil {
+		printFilterError(err)
 		log.WithField("error", err).Fatal("Failed to filter target agents")
 	}
 	return v
 }
 
+// printFilterError shows where the filter expression is invalid.
+func printFilterError(err error) {
+	if e, ok := err.(*filter.SyntaxError); ok {
+		fmt.Fprintln(os.Stderr, e.Caret())
+	}
+}
+
 func filterAgents(agents []string, s string) ([]string, error) {
 	if s == "" {
 		return agents, nil
@@ -98,11 +153,13 @@ func (c Capabilities) Copy() Capabilities {
 	return caps
 }
 
+// Agents returns agents sorted by address.
 func (c Capabilities) Agents() []string {
 	out := make([]string, 0, len(c))
 	for agent := range c {
 		out = append(out, agent)
 	}
+	sort.Strings(out)
 	return out
 }
 
@@ -129,6 +186,7 @@ func gatherCapabilities(agents []string) Capabilities {
 				log.WithField("agent", agent).Info("Temporary unavailable")
 				return
 			}
+			annotateCapability(agent, &cap)
 			caps[agent] = &cap
 		}(agent)
 	}
@@ -136,11 +194,42 @@ func gatherCapabilities(agents []string) Capabilities {
 	return caps
 }
 
-func findBestAgent(m *docker.Manifest, caps Capabilities) string {
+// annotateCapability adds groups and labels of the agent known by the
+// inventory. Labels reported by the agent take precedence.
+func annotateCapability(agent string, cap *rpc.Capability) {
+	if gopts.conf == nil {
+		return
+	}
+	inv := &gopts.conf.Inventory
+	cap.Groups = inv.GroupsOf(agent)
+	for k, v := range inv.Labels(agent) {
+		if cap.Labels == nil {
+			cap.Labels = make(map[string]string)
+		}
+		if _, ok := cap.Labels[k]; !ok {
+			cap.Labels[k] = v
+		}
+	}
+}
+
+// restrictExpr parses the restrict expression of the manifest, which is nil
+// if there is none. The docker package can't depend on the filter package, so
+// it's validated here and the result is passed to findBestAgent.
+func restrictExpr(m *docker.Manifest) (filter.Evaluator, error) {
+	if m.Restrict.Expr == "" {
+		return nil, nil
+	}
+	return filter.Parse(m.Restrict.Expr)
+}
+
+// findBestAgent returns the least loaded agent meeting the manifest. expr is
+// the parsed restrict expression if any.
+func findBestAgent(m *docker.Manifest, caps Capabilities, expr filter.Evaluator) string {
 	if m.Replace == "" {
 		// Check availability of name
 		caps.Filter(func(cap *rpc.Capability) bool {
-			return !stringSlice(cap.AllNames).Contains(m.Name)
+			return !stringSlice(cap.AllNames).Contains(m.Name) &&
+				!stringSlice(cap.ReservedNames).Contains(m.Name)
 		})
 	} else {
 		// Check existence of a container to be replaced
@@ -158,7 +247,8 @@ func findBestAgent(m *docker.Manifest, caps Capabilities) string {
 			}
 		} else {
 			caps.Filter(func(cap *rpc.Capability) bool {
-				return !stringSlice(cap.AllNames).Contains(m.Name)
+				return !stringSlice(cap.AllNames).Contains(m.Name) &&
+					!stringSlice(cap.ReservedNames).Contains(m.Name)
 			})
 		}
 	}
@@ -166,6 +256,12 @@ func findBestAgent(m *docker.Manifest, caps Capabilities) string {
 	// Check availability of ports
 	caps.Filter(func(cap *rpc.Capability) bool {
 		for _, p := range m.Ports {
+			if p.Dynamic() {
+				if !hasFreePort(p, cap.UsedPorts) {
+					return false
+				}
+				continue
+			}
 			becomeAvailable, ok := cap.Containers[m.Replace]
 			if ok && portSpecSlice(becomeAvailable.Ports).Contains(p.HostPort) {
 				continue
@@ -229,11 +325,40 @@ func findBestAgent(m *docker.Manifest, caps Capabilities) string {
 		})
 	}
 
-	// Choice agent that has least active containers
+	// Filter expression restriction
+	if expr != nil {
+		caps.Filter(func(cap *rpc.Capability) bool {
+			return expr.Eval(cap)
+		})
+	}
+
+	return leastLoadedAgent(caps)
+}
+
+// hasFreePort reports whether a host port in the range of the spec is free.
+func hasFreePort(p docker.PortSpec, used []int64) bool {
+	n := p.HostPortMax - p.HostPortMin + 1
+	for _, port := range used {
+		if port >= p.HostPortMin && port <= p.HostPortMax {
+			n--
+		}
+	}
+	return n > 0
+}
+
+// leastLoadedAgent returns an agent that has least active containers,
+// counting ones being created by submits in progress.
+func leastLoadedAgent(caps Capabilities) string {
 	running := 1024 * 1024 * 1024 // it's large enough
 	var agent string
 	for addr, cap := range caps {
-		if n := len(cap.UsedNames); n < running {
+		n := len(cap.UsedNames)
+		for _, name := range cap.ReservedNames {
+			if !stringSlice(cap.UsedNames).Contains(name) {
+				n++
+			}
+		}
+		if n < running {
 			running = n
 			agent = addr
 		}
@@ -241,35 +366,100 @@ func findBestAgent(m *docker.Manifest, caps Capabilities) string {
 	return agent
 }
 
-func resolveExLinks(m *docker.Manifest, caps Capabilities) ([]*rpc.ExLink, error) {
+// zoneLabel is the label compared by exlinks in the zone mode.
+const zoneLabel = "zone"
+
+// resolveExLinks chooses containers to be linked from the container to run
+// on the agent according to the mode of each exlink.
+func resolveExLinks(m *docker.Manifest, caps Capabilities, agent string) ([]*rpc.ExLink, error) {
 	var out []*rpc.ExLink
 	for _, l := range m.ExLinks {
 		caps2 := caps.Copy()
 		caps2.Filter(func(cap *rpc.Capability) bool {
 			return stringSlice(cap.UsedNames).Contains(l.Name) &&
-				len(cap.IPAddrs) > 0
+				len(linkAddrs(cap, l.Network)) > 0
 		})
 		if len(caps2) == 0 {
 			return nil, errors.New("No linkable containers")
 		}
-		_, cap := choice(caps2)
-		ci := cap.Containers[l.Name]
-		for _, port := range ci.Ports {
-			addr := port.HostIP
-			if addr == "0.0.0.0" {
-				addr = cap.IPAddrs[0]
+
+		var cap *rpc.Capability
+		switch l.Mode {
+		case docker.LinkZone:
+			if self, ok := caps[agent]; ok && self.Labels[zoneLabel] != "" {
+				zone := self.Labels[zoneLabel]
+				near := caps2.Copy()
+				near.Filter(func(cap *rpc.Capability) bool {
+					return cap.Labels[zoneLabel] == zone
+				})
+				if len(near) > 0 {
+					_, cap = choice(near)
+					break
+				}
 			}
-			out = append(out, &rpc.ExLink{
-				Name:    l.Alias,
-				Exposed: string(port.Exposed),
-				Addr:    addr,
-				Port:    int(port.HostPort),
-			})
+			_, cap = choice(caps2)
+		case docker.LinkHash:
+			_, cap = hashChoice(caps2, m.Name+"/"+l.Name)
+		default:
+			_, cap = choice(caps2)
 		}
+
+		exlinks := linkPorts(l, cap)
+		if l.Mode == docker.LinkAll {
+			endpoints := make(map[string][]string)
+			for _, agent := range caps2.Agents() {
+				for _, exl := range linkPorts(l, caps2[agent]) {
+					endpoints[exl.Exposed] = append(endpoints[exl.Exposed], net.JoinHostPort(exl.Addr, strconv.Itoa(exl.Port)))
+				}
+			}
+			for _, exl := range exlinks {
+				exl.Endpoints = endpoints[exl.Exposed]
+			}
+		}
+		out = append(out, exlinks...)
 	}
 	return out, nil
 }
 
+// linkPorts returns exlinks to the published ports of the container on the
+// agent.
+func linkPorts(l docker.Link, cap *rpc.Capability) []*rpc.ExLink {
+	var out []*rpc.ExLink
+	ci := cap.Containers[l.Name]
+	for _, port := range ci.Ports {
+		addr := port.HostIP
+		if addr == "" || net.ParseIP(addr).IsUnspecified() {
+			addr = linkAddrs(cap, l.Network)[0]
+		}
+		out = append(out, &rpc.ExLink{
+			Name:    l.Alias,
+			Exposed: string(port.Exposed),
+			Addr:    addr,
+			Port:    int(port.HostPort),
+		})
+	}
+	return out
+}
+
+// linkAddrs returns addresses of the agent in the network, which is a name
+// defined by the agent or a CIDR. All addresses are returned if it's empty.
+func linkAddrs(cap *rpc.Capability, network string) []string {
+	if network == "" {
+		return cap.IPAddrs
+	}
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return cap.Networks[network]
+	}
+	var out []string
+	for _, addr := range cap.IPAddrs {
+		if ipnet.Contains(net.ParseIP(addr)) {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
 func choice(caps Capabilities) (string, *rpc.Capability) {
 	var keys []string
 	for k := range caps {
@@ -280,6 +470,22 @@ func choice(caps Capabilities) (string, *rpc.Capability) {
 	return keys[n], caps[keys[n]]
 }
 
+// hashChoice is like choice but always returns the same agent for the key as
+// long as the agent is in caps. It's rendezvous hashing, so keys on other
+// agents don't move when an agent is added or removed.
+func hashChoice(caps Capabilities, key string) (string, *rpc.Capability) {
+	var agent string
+	var max uint64
+	for _, k := range caps.Agents() {
+		h := fnv.New64a()
+		h.Write([]byte(key + "\x00" + k))
+		if score := h.Sum64(); agent == "" || score > max {
+			agent, max = k, score
+		}
+	}
+	return agent, caps[agent]
+}
+
 type stringSlice []string
 
 func (ss stringSlice) Contains(s string) bool {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yosisa/craft/docker"
+	"github.com/yosisa/craft/rpc"
+)
+
+func TestHashChoice(t *testing.T) {
+	caps := make(Capabilities)
+	for i := 1; i <= 5; i++ {
+		caps[fmt.Sprintf("10.0.0.%d:7300", i)] = &rpc.Capability{}
+	}
+	chosen := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("web%d/db", i)
+		agent, cap := hashChoice(caps, key)
+		if cap != caps[agent] {
+			t.Fatalf("%s: capability of another agent", key)
+		}
+		chosen[key] = agent
+	}
+
+	// removing an agent moves only keys which were on it
+	removed := "10.0.0.3:7300"
+	delete(caps, removed)
+	for key, prev := range chosen {
+		agent, _ := hashChoice(caps, key)
+		if prev != removed && agent != prev {
+			t.Errorf("%s moved from %s to %s", key, prev, agent)
+		}
+		if agent == removed {
+			t.Errorf("%s: removed agent chosen", key)
+		}
+	}
+}
+
+func testCapabilities() Capabilities {
+	return Capabilities{
+		"10.0.0.1:7300": {Agent: "agent1", Labels: map[string]string{"zone": "a"}, UsedNames: []string{"redis"}},
+		"10.0.0.2:7300": {Agent: "agent2", Labels: map[string]string{"zone": "b"}},
+		"10.0.0.3:7300": {Agent: "agent3", Labels: map[string]string{"zone": "b"}, UsedNames: []string{"db", "cache"}},
+	}
+}
+
+func TestFindBestAgentExpr(t *testing.T) {
+	data := []struct {
+		expr     string
+		expected string
+	}{
+		{"", "10.0.0.2:7300"},
+		{"L@zone:a", "10.0.0.1:7300"},
+		{"L@zone:b and C@db", "10.0.0.3:7300"},
+		{"not L@zone:b", "10.0.0.1:7300"},
+		{"L@zone:c", ""},
+	}
+	for _, test := range data {
+		m := &docker.Manifest{Name: "web", Restrict: docker.Restrict{Expr: test.expr}}
+		expr, err := restrictExpr(m)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		if agent := findBestAgent(m, testCapabilities(), expr); agent != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.expr, agent, test.expected)
+		}
+	}
+}
+
+func TestRestrictExprInvalid(t *testing.T) {
+	m := &docker.Manifest{Restrict: docker.Restrict{Expr: "L@zone:a and"}}
+	if _, err := restrictExpr(m); err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+	m.Restrict.Expr = ""
+	if expr, err := restrictExpr(m); expr != nil || err != nil {
+		t.Fatalf("expected nothing without an expression, but %v %v", expr, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dustin/go-humanize"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdMembers struct {
+	FormatOptions
+}
+
+func (opts *CmdMembers) Execute(args []string) error {
+	members, err := rpc.Members(gopts.ParseConfig().Agents)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to get cluster members")
+	}
+	if opts.Formatted() {
+		return opts.WriteRecords(os.Stdout, members)
+	}
+
+	var tw tableWriter
+	tw.Append("NAME", "ADDRESS", "STATE", "LAST SEEN")
+	for _, m := range members {
+		tw.Append(m.Name, m.Addr, m.State, humanize.Time(m.LastSeen))
+	}
+	tw.Write(os.Stdout, "")
+	return nil
+}
+
+func init() {
+	parser.AddCommand("members", "List cluster members known by agents", "", &CmdMembers{})
+}
@@ -0,0 +1,586 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A session multiplexes streams over a single connection. Each frame has a
+// header consists of version, type, flags, stream id and length. The length
+// is the size of the payload for data frames, the window delta for window
+// update frames and an opaque value for ping frames. Each stream has a
+// receive window and the sender never sends more than the window.
+
+const (
+	protoVersion  uint8 = 0
+	headerSize          = 12
+	initialWindow       = 256 * 1024
+	maxFrameSize        = 64 * 1024
+	acceptBacklog       = 256
+)
+
+const (
+	typeData uint8 = iota
+	typeWindowUpdate
+	typePing
+)
+
+const (
+	flagSYN uint16 = 1 << iota
+	flagACK
+	flagFIN
+	flagRST
+)
+
+var (
+	ErrSessionClosed           = errors.New("mux: session closed")
+	ErrStreamClosed            = errors.New("mux: stream closed")
+	ErrStreamReset             = errors.New("mux: stream reset")
+	ErrInvalidFrame            = errors.New("mux: invalid frame")
+	ErrTimeout       net.Error = timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "mux: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type header [headerSize]byte
+
+func (h *header) encode(typ uint8, flags uint16, id, length uint32) {
+	h[0] = protoVersion
+	h[1] = typ
+	binary.BigEndian.PutUint16(h[2:4], flags)
+	binary.BigEndian.PutUint32(h[4:8], id)
+	binary.BigEndian.PutUint32(h[8:12], length)
+}
+
+func (h *header) version() uint8   { return h[0] }
+func (h *header) typ() uint8       { return h[1] }
+func (h *header) flags() uint16    { return binary.BigEndian.Uint16(h[2:4]) }
+func (h *header) streamID() uint32 { return binary.BigEndian.Uint32(h[4:8]) }
+func (h *header) length() uint32   { return binary.BigEndian.Uint32(h[8:12]) }
+
+type Session struct {
+	conn    net.Conn
+	nextID  uint32
+	streams map[uint32]*Stream
+	accept  chan *Stream
+	pingID  uint32
+	pings   map[uint32]chan struct{}
+	m       sync.Mutex
+	wm      sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Client returns a session of the client side of the connection.
+func Client(conn net.Conn) *Session {
+	return newSession(conn, 1)
+}
+
+// Server returns a session of the server side of the connection.
+func Server(conn net.Conn) *Session {
+	return newSession(conn, 2)
+}
+
+func newSession(conn net.Conn, id uint32) *Session {
+	s := &Session{
+		conn:    conn,
+		nextID:  id,
+		streams: make(map[uint32]*Stream),
+		accept:  make(chan *Stream, acceptBacklog),
+		pings:   make(map[uint32]chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open opens a new stream.
+func (s *Session) Open() (*Stream, error) {
+	s.m.Lock()
+	if s.closed() {
+		s.m.Unlock()
+		return nil, ErrSessionClosed
+	}
+	st := newStream(s, s.nextID)
+	s.nextID += 2
+	s.streams[st.id] = st
+	s.m.Unlock()
+
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, st.id, 0, nil); err != nil {
+		s.remove(st.id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// Accept waits for a stream opened by the peer.
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		return nil, ErrSessionClosed
+	}
+}
+
+// Dial opens a new stream and sends the type byte so that the peer can
+// dispatch it by Mux.
+func (s *Session) Dial(typ byte) (net.Conn, error) {
+	st, err := s.Open()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(st, typ)
+}
+
+// Ping sends a ping and waits for the response.
+func (s *Session) Ping(timeout time.Duration) error {
+	c := make(chan struct{})
+	s.m.Lock()
+	id := s.pingID
+	s.pingID++
+	s.pings[id] = c
+	s.m.Unlock()
+	defer func() {
+		s.m.Lock()
+		delete(s.pings, id)
+		s.m.Unlock()
+	}()
+
+	if err := s.writeFrame(typePing, flagSYN, 0, id, nil); err != nil {
+		return err
+	}
+	select {
+	case <-c:
+		return nil
+	case <-s.done:
+		return ErrSessionClosed
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+}
+
+// Close closes the session and all of its streams.
+func (s *Session) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+		s.m.Lock()
+		for _, st := range s.streams {
+			st.notify()
+		}
+		s.m.Unlock()
+	})
+	return err
+}
+
+// Done returns a channel which is closed when the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) writeFrame(typ uint8, flags uint16, id, length uint32, body []byte) error {
+	if s.closed() {
+		return ErrSessionClosed
+	}
+	var h header
+	h.encode(typ, flags, id, length)
+	s.wm.Lock()
+	defer s.wm.Unlock()
+	if _, err := s.conn.Write(h[:]); err != nil {
+		return err
+	}
+	if len(body) > 0 {
+		if _, err := s.conn.Write(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) recvLoop() {
+	defer s.Close()
+	var h header
+	for {
+		if _, err := io.ReadFull(s.conn, h[:]); err != nil {
+			return
+		}
+		if h.version() != protoVersion {
+			return
+		}
+		switch h.typ() {
+		case typeData, typeWindowUpdate:
+			if err := s.handleStream(&h); err != nil {
+				return
+			}
+		case typePing:
+			s.handlePing(&h)
+		default:
+			return
+		}
+	}
+}
+
+func (s *Session) handleStream(h *header) error {
+	id, flags := h.streamID(), h.flags()
+	var body []byte
+	if h.typ() == typeData && h.length() > 0 {
+		if h.length() > initialWindow {
+			return ErrInvalidFrame
+		}
+		body = make([]byte, h.length())
+		if _, err := io.ReadFull(s.conn, body); err != nil {
+			return err
+		}
+	}
+
+	s.m.Lock()
+	st, ok := s.streams[id]
+	if flags&flagSYN != 0 && !ok {
+		st = newStream(s, id)
+		s.streams[id] = st
+		select {
+		case s.accept <- st:
+		default:
+			// too many streams waiting to be accepted
+			delete(s.streams, id)
+			s.m.Unlock()
+			go s.writeFrame(typeWindowUpdate, flagRST, id, 0, nil)
+			return nil
+		}
+	}
+	s.m.Unlock()
+	if st == nil {
+		return nil
+	}
+
+	if h.typ() == typeData {
+		st.recv(body)
+	} else {
+		st.updateWindow(h.length())
+	}
+	if flags&flagFIN != 0 {
+		st.remoteClose()
+	}
+	if flags&flagRST != 0 {
+		st.reset()
+	}
+	return nil
+}
+
+func (s *Session) handlePing(h *header) {
+	if h.flags()&flagSYN != 0 {
+		go s.writeFrame(typePing, flagACK, 0, h.length(), nil)
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if c, ok := s.pings[h.length()]; ok {
+		close(c)
+		delete(s.pings, h.length())
+	}
+}
+
+func (s *Session) remove(id uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.streams, id)
+}
+
+// Stream is a bidirectional stream in a session.
+type Stream struct {
+	s             *Session
+	id            uint32
+	buf           bytes.Buffer
+	recvWindow    uint32
+	sendWindow    uint32
+	consumed      uint32
+	localClosed   bool
+	finSent       bool
+	remoteClosed  bool
+	isReset       bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readNotify    chan struct{}
+	writeNotify   chan struct{}
+	m             sync.Mutex
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		s:           s,
+		id:          id,
+		recvWindow:  initialWindow,
+		sendWindow:  initialWindow,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func (st *Stream) Read(b []byte) (int, error) {
+	for {
+		st.m.Lock()
+		switch {
+		case st.localClosed:
+			st.m.Unlock()
+			return 0, ErrStreamClosed
+		case st.buf.Len() > 0:
+			n, _ := st.buf.Read(b)
+			st.consumed += uint32(n)
+			var delta uint32
+			if st.consumed >= initialWindow/2 {
+				delta, st.consumed = st.consumed, 0
+				st.recvWindow += delta
+			}
+			st.m.Unlock()
+			if delta > 0 {
+				st.s.writeFrame(typeWindowUpdate, 0, st.id, delta, nil)
+			}
+			return n, nil
+		case st.isReset:
+			st.m.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteClosed:
+			st.m.Unlock()
+			return 0, io.EOF
+		case st.s.closed():
+			st.m.Unlock()
+			return 0, ErrSessionClosed
+		}
+		deadline := st.readDeadline
+		st.m.Unlock()
+		if err := st.wait(st.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *Stream) Write(b []byte) (int, error) {
+	var n int
+	for n < len(b) {
+		st.m.Lock()
+		switch {
+		case st.finSent:
+			st.m.Unlock()
+			return n, ErrStreamClosed
+		case st.isReset:
+			st.m.Unlock()
+			return n, ErrStreamReset
+		case st.s.closed():
+			st.m.Unlock()
+			return n, ErrSessionClosed
+		case st.sendWindow == 0:
+			deadline := st.writeDeadline
+			st.m.Unlock()
+			if err := st.wait(st.writeNotify, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		size := uint32(len(b) - n)
+		if size > st.sendWindow {
+			size = st.sendWindow
+		}
+		if size > maxFrameSize {
+			size = maxFrameSize
+		}
+		st.sendWindow -= size
+		st.m.Unlock()
+
+		if err := st.s.writeFrame(typeData, 0, st.id, size, b[n:n+int(size)]); err != nil {
+			return n, err
+		}
+		n += int(size)
+	}
+	return n, nil
+}
+
+func (st *Stream) wait(c chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-c:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	}
+}
+
+// Close sends FIN to the peer if not yet. Data arriving after that are
+// discarded.
+func (st *Stream) Close() error {
+	st.m.Lock()
+	if st.localClosed {
+		st.m.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.buf.Reset()
+	fin := !st.finSent
+	st.finSent = true
+	done := st.remoteClosed || st.isReset
+	st.m.Unlock()
+	st.notify()
+
+	var err error
+	if fin {
+		err = st.s.writeFrame(typeData, flagFIN, st.id, 0, nil)
+	}
+	if done {
+		st.s.remove(st.id)
+	}
+	return err
+}
+
+// CloseWrite sends FIN to the peer but still reads from the stream.
+func (st *Stream) CloseWrite() error {
+	st.m.Lock()
+	if st.finSent {
+		st.m.Unlock()
+		return nil
+	}
+	st.finSent = true
+	st.m.Unlock()
+	st.notify()
+	return st.s.writeFrame(typeData, flagFIN, st.id, 0, nil)
+}
+
+func (st *Stream) recv(b []byte) {
+	st.m.Lock()
+	if uint32(len(b)) > st.recvWindow {
+		// the peer ignored the window
+		st.m.Unlock()
+		go st.s.writeFrame(typeWindowUpdate, flagRST, st.id, 0, nil)
+		st.reset()
+		return
+	}
+	if st.localClosed {
+		// nobody reads, give the window back immediately
+		st.m.Unlock()
+		if len(b) > 0 {
+			go st.s.writeFrame(typeWindowUpdate, 0, st.id, uint32(len(b)), nil)
+		}
+		return
+	}
+	st.recvWindow -= uint32(len(b))
+	st.buf.Write(b)
+	st.m.Unlock()
+	st.notify()
+}
+
+func (st *Stream) updateWindow(delta uint32) {
+	st.m.Lock()
+	st.sendWindow += delta
+	st.m.Unlock()
+	st.notify()
+}
+
+func (st *Stream) remoteClose() {
+	st.m.Lock()
+	st.remoteClosed = true
+	done := st.localClosed
+	st.m.Unlock()
+	st.notify()
+	if done {
+		st.s.remove(st.id)
+	}
+}
+
+func (st *Stream) reset() {
+	st.m.Lock()
+	st.isReset = true
+	st.m.Unlock()
+	st.notify()
+	st.s.remove(st.id)
+}
+
+// notify wakes up blocked readers and writers to check the state again.
+func (st *Stream) notify() {
+	for _, c := range []chan struct{}{st.readNotify, st.writeNotify} {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Session returns the session the stream belongs to.
+func (st *Stream) Session() *Session {
+	return st.s
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return st.s.conn.LocalAddr()
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.s.conn.RemoteAddr()
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.m.Lock()
+	st.readDeadline, st.writeDeadline = t, t
+	st.m.Unlock()
+	st.notify()
+	return nil
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.m.Lock()
+	st.readDeadline = t
+	st.m.Unlock()
+	st.notify()
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.m.Lock()
+	st.writeDeadline = t
+	st.m.Unlock()
+	st.notify()
+	return nil
+}
+
+// ServeSession accepts streams of the connection and dispatches them.
+func (m *Mux) ServeSession(c net.Conn) error {
+	s := Server(c)
+	defer s.Close()
+	for {
+		st, err := s.Accept()
+		if err != nil {
+			return err
+		}
+		go m.Dispatch(st)
+	}
+}
+
+func ServeSession(c net.Conn) error {
+	return DefaultMux.ServeSession(c)
+}
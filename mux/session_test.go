@@ -0,0 +1,149 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SessionSuite struct {
+	client *Session
+	server *Session
+}
+
+var _ = Suite(&SessionSuite{})
+
+func (s *SessionSuite) SetUpTest(c *C) {
+	c1, c2 := net.Pipe()
+	s.client = Client(c1)
+	s.server = Server(c2)
+}
+
+func (s *SessionSuite) TearDownTest(c *C) {
+	s.client.Close()
+	s.server.Close()
+}
+
+func echo(s *Session) {
+	for {
+		st, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(st, st)
+			st.Close()
+		}()
+	}
+}
+
+func (s *SessionSuite) TestStreams(c *C) {
+	go echo(s.server)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			st, err := s.client.Open()
+			c.Assert(err, IsNil)
+			msg := bytes.Repeat([]byte{byte(i)}, 100)
+			_, err = st.Write(msg)
+			c.Assert(err, IsNil)
+			b := make([]byte, len(msg))
+			_, err = io.ReadFull(st, b)
+			c.Assert(err, IsNil)
+			c.Assert(b, DeepEquals, msg)
+			st.Close()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (s *SessionSuite) TestFlowControl(c *C) {
+	// larger than the window, the writer must wait for the reader
+	data := make([]byte, initialWindow*4+123)
+	rand.Read(data)
+	go func(server *Session) {
+		st, err := server.Accept()
+		if err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		b, _ := ioutil.ReadAll(st)
+		st.Write([]byte{byte(len(b) % 256)})
+		st.Write(b)
+		st.Close()
+	}(s.server)
+
+	st, err := s.client.Open()
+	c.Assert(err, IsNil)
+	_, err = st.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(st.CloseWrite(), IsNil)
+	b, err := ioutil.ReadAll(st)
+	c.Assert(err, IsNil)
+	c.Assert(b[0], Equals, byte(len(data)%256))
+	c.Assert(bytes.Equal(b[1:], data), Equals, true)
+}
+
+func (s *SessionSuite) TestClose(c *C) {
+	st, err := s.client.Open()
+	c.Assert(err, IsNil)
+	sst, err := s.server.Accept()
+	c.Assert(err, IsNil)
+
+	c.Assert(st.Close(), IsNil)
+	_, err = sst.Read(make([]byte, 1))
+	c.Assert(err, Equals, io.EOF)
+	_, err = st.Write([]byte("x"))
+	c.Assert(err, Equals, ErrStreamClosed)
+	c.Assert(sst.Close(), IsNil)
+
+	s.client.Close()
+	_, err = s.client.Open()
+	c.Assert(err, Equals, ErrSessionClosed)
+	_, err = s.server.Accept()
+	c.Assert(err, Equals, ErrSessionClosed)
+}
+
+func (s *SessionSuite) TestDeadline(c *C) {
+	st, err := s.client.Open()
+	c.Assert(err, IsNil)
+	st.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err = st.Read(make([]byte, 1))
+	c.Assert(err, Equals, ErrTimeout)
+}
+
+func (s *SessionSuite) TestPing(c *C) {
+	c.Assert(s.client.Ping(time.Second), IsNil)
+	c.Assert(s.server.Ping(time.Second), IsNil)
+}
+
+func (s *SessionSuite) TestDispatch(c *C) {
+	m := &Mux{}
+	m.Handle(0x05, HandlerFunc(func(c net.Conn) {
+		c.Write([]byte("hello"))
+		c.Close()
+	}))
+	c1, c2 := net.Pipe()
+	client := Client(c1)
+	defer client.Close()
+	go m.ServeSession(c2)
+
+	conn, err := client.Dial(0x05)
+	c.Assert(err, IsNil)
+	b, err := ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "hello")
+
+	conn, err = client.Dial(0x06)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(conn)
+	c.Assert(err, IsNil)
+}
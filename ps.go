@@ -4,41 +4,86 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/dustin/go-humanize"
+	dockerclient "github.com/fsouza/go-dockerclient"
 	"github.com/yosisa/craft/docker"
 	"github.com/yosisa/craft/rpc"
 )
 
 type CmdPs struct {
-	All  bool `short:"a" long:"all" description:"Show all containers"`
-	Full bool `long:"full" description:"Show full command"`
-	Args struct {
+	FormatOptions
+	All     bool     `short:"a" long:"all" description:"Show all containers"`
+	Full    bool     `long:"full" description:"Show full command"`
+	Filters []string `short:"f" long:"filter" description:"Filter containers by KEY=VALUE (name, image, status, port, label, newer, older)"`
+	Sort    string   `long:"sort" description:"Sort containers by the key" choice:"agent" choice:"name" choice:"created" choice:"status"`
+	Flat    bool     `long:"flat" description:"Show containers of all agents in a table"`
+	Args    struct {
 		Containers []string `positional-arg-name:"CONTAINER"`
 	} `positional-args:"yes"`
 }
 
+type containerRecord struct {
+	Agent string
+	Name  string
+	State string
+	dockerclient.APIContainers
+}
+
 func (opts *CmdPs) Execute(args []string) error {
-	containers, err := rpc.ListContainers(gopts.agents(), opts.All)
+	cf, err := parseContainerFilter(opts.Filters)
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid filter")
+	}
+	containers, err := rpc.ListContainers(gopts.agents(), opts.All || cf.needAll())
 	logRPCError(err)
+
+	var records []containerRecord
 	for _, agent := range sortedKeys(containers) {
-		fmt.Printf("[%s]\n", agent)
 		cons := containers[agent].(*rpc.ListContainersResponse).FilterByNames(opts.Args.Containers)
-		if len(cons) == 0 {
-			fmt.Println()
-			continue
+		for _, c := range cons {
+			r := containerRecord{agent, docker.CanonicalName(c.Names), containerState(c.Status), c}
+			if cf.match(&r) {
+				records = append(records, r)
+			}
 		}
+	}
+	if opts.Sort != "" {
+		sortContainerRecords(records, opts.Sort)
+	}
+
+	switch {
+	case opts.Formatted():
+		return opts.WriteRecords(os.Stdout, records)
+	case opts.Flat:
+		if len(records) > 0 {
+			var tw tableWriter
+			tw.Append("AGENT", "CONTAINER ID", "NAME", "IMAGE", "COMMAND", "CREATED", "STATUS", "PORTS")
+			for _, r := range records {
+				tw.Append(append([]string{r.Agent}, opts.columns(&r)...)...)
+			}
+			tw.Write(os.Stdout, "")
+		}
+		return nil
+	}
+
+	for _, agent := range sortedKeys(containers) {
+		fmt.Printf("[%s]\n", agent)
 		var tw tableWriter
-		tw.Append("CONTAINER ID", "NAME", "IMAGE", "COMMAND", "CREATED", "STATUS", "PORTS")
-		for _, c := range cons {
-			cmd := c.Command
-			if len(cmd) > 20 && !opts.Full {
-				cmd = cmd[:20]
+		for _, r := range records {
+			if r.Agent != agent {
+				continue
+			}
+			if tw.rows == nil {
+				tw.Append("CONTAINER ID", "NAME", "IMAGE", "COMMAND", "CREATED", "STATUS", "PORTS")
 			}
-			tw.Append(c.ID[:12], docker.CanonicalName(c.Names), c.Image, cmd,
-				humanize.Time(time.Unix(c.Created, 0)), c.Status, docker.FormatPorts(c.Ports))
+			tw.Append(opts.columns(&r)...)
 		}
 		tw.Write(os.Stdout, "  ")
 		fmt.Println()
@@ -46,6 +91,154 @@ func (opts *CmdPs) Execute(args []string) error {
 	return nil
 }
 
+func (opts *CmdPs) columns(r *containerRecord) []string {
+	cmd := r.Command
+	if len(cmd) > 20 && !opts.Full {
+		cmd = cmd[:20]
+	}
+	return []string{r.ID[:12], r.Name, r.Image, cmd,
+		humanize.Time(time.Unix(r.Created, 0)), r.Status, docker.FormatPorts(r.Ports)}
+}
+
+// containerState returns a state of the container from its status text.
+func containerState(status string) string {
+	switch {
+	case status == "":
+		return "created"
+	case strings.HasPrefix(status, "Up") && strings.HasSuffix(status, "(Paused)"):
+		return "paused"
+	case strings.HasPrefix(status, "Up"):
+		return "running"
+	case strings.HasPrefix(status, "Restarting"):
+		return "restarting"
+	case strings.HasPrefix(status, "Exited"):
+		return "exited"
+	}
+	return strings.ToLower(strings.SplitN(status, " ", 2)[0])
+}
+
+func sortContainerRecords(records []containerRecord, key string) {
+	less := map[string]func(a, b *containerRecord) bool{
+		"agent":   func(a, b *containerRecord) bool { return a.Agent < b.Agent },
+		"name":    func(a, b *containerRecord) bool { return a.Name < b.Name },
+		"created": func(a, b *containerRecord) bool { return a.Created > b.Created },
+		"status":  func(a, b *containerRecord) bool { return a.State < b.State },
+	}[key]
+	sort.Stable(byContainerRecord{records, func(a, b *containerRecord) bool {
+		switch {
+		case less(a, b):
+			return true
+		case less(b, a):
+			return false
+		case a.Agent != b.Agent:
+			return a.Agent < b.Agent
+		}
+		return a.Name < b.Name
+	}})
+}
+
+type byContainerRecord struct {
+	records []containerRecord
+	less    func(a, b *containerRecord) bool
+}
+
+func (s byContainerRecord) Len() int {
+	return len(s.records)
+}
+
+func (s byContainerRecord) Swap(i, j int) {
+	s.records[i], s.records[j] = s.records[j], s.records[i]
+}
+
+func (s byContainerRecord) Less(i, j int) bool {
+	return s.less(&s.records[i], &s.records[j])
+}
+
+// containerFilter holds predicates grouped by the key. Predicates of the
+// same key are ORed and different keys are ANDed.
+type containerFilter map[string][]func(*containerRecord) bool
+
+func parseContainerFilter(filters []string) (containerFilter, error) {
+	cf := make(containerFilter)
+	for _, f := range filters {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Filter must be KEY=VALUE: %s", f)
+		}
+		key, value := parts[0], parts[1]
+		var pred func(*containerRecord) bool
+		switch key {
+		case "name":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, err
+			}
+			pred = func(r *containerRecord) bool { return re.MatchString(r.Name) }
+		case "image":
+			pred = func(r *containerRecord) bool {
+				image, _ := docker.SplitImageTag(r.Image)
+				return r.Image == value || image == value
+			}
+		case "status":
+			pred = func(r *containerRecord) bool { return r.State == value }
+		case "port":
+			port, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			pred = func(r *containerRecord) bool {
+				for _, p := range r.Ports {
+					if p.PublicPort == port {
+						return true
+					}
+				}
+				return false
+			}
+		case "label":
+			kv := strings.SplitN(value, "=", 2)
+			pred = func(r *containerRecord) bool {
+				v, ok := r.Labels[kv[0]]
+				return ok && (len(kv) == 1 || v == kv[1])
+			}
+		case "newer", "older":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, err
+			}
+			newer := key == "newer"
+			pred = func(r *containerRecord) bool {
+				age := time.Since(time.Unix(r.Created, 0))
+				return (age < d) == newer
+			}
+		default:
+			return nil, fmt.Errorf("Unknown filter key: %s", key)
+		}
+		cf[key] = append(cf[key], pred)
+	}
+	return cf, nil
+}
+
+// needAll reports whether the filter may match non-running containers.
+func (cf containerFilter) needAll() bool {
+	_, ok := cf["status"]
+	return ok
+}
+
+func (cf containerFilter) match(r *containerRecord) bool {
+	for _, preds := range cf {
+		var ok bool
+		for _, pred := range preds {
+			if ok = pred(r); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
 type tableWriter struct {
 	rows  [][]interface{}
 	width []int
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	dockerclient "github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerState(t *testing.T) {
+	data := []struct {
+		status   string
+		expected string
+	}{
+		{"", "created"},
+		{"Up 2 hours", "running"},
+		{"Up 5 minutes (Paused)", "paused"},
+		{"Restarting (1) 3 seconds ago", "restarting"},
+		{"Exited (0) 2 days ago", "exited"},
+		{"Dead", "dead"},
+		{"Removal In Progress", "removal"},
+	}
+	for _, test := range data {
+		if v := containerState(test.status); v != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.status, v, test.expected)
+		}
+	}
+}
+
+func testContainerRecord() *containerRecord {
+	return &containerRecord{
+		Agent: "agent1",
+		Name:  "web-1",
+		State: "running",
+		APIContainers: dockerclient.APIContainers{
+			Image:   "nginx:1.9",
+			Created: time.Now().Add(-2 * time.Hour).Unix(),
+			Ports:   []dockerclient.APIPort{{PrivatePort: 80, PublicPort: 8080}},
+			Labels:  map[string]string{"env": "prd", "gpu": ""},
+		},
+	}
+}
+
+func TestParseContainerFilter(t *testing.T) {
+	data := []struct {
+		filters  []string
+		expected bool
+	}{
+		{nil, true},
+		{[]string{"name=^web-"}, true},
+		{[]string{"name=^db-"}, false},
+		{[]string{"name=^db-", "name=^web-"}, true},
+		{[]string{"image=nginx"}, true},
+		{[]string{"image=nginx:1.9"}, true},
+		{[]string{"image=nginx:1.8"}, false},
+		{[]string{"status=running"}, true},
+		{[]string{"status=exited"}, false},
+		{[]string{"port=8080"}, true},
+		{[]string{"port=80"}, false},
+		{[]string{"label=env"}, true},
+		{[]string{"label=env=prd"}, true},
+		{[]string{"label=env=dev"}, false},
+		{[]string{"label=gpu="}, true},
+		{[]string{"label=zone"}, false},
+		{[]string{"newer=3h"}, true},
+		{[]string{"older=3h"}, false},
+		{[]string{"name=^web-", "status=exited"}, false},
+		{[]string{"name=^web-", "status=exited", "status=running"}, true},
+	}
+	for _, test := range data {
+		cf, err := parseContainerFilter(test.filters)
+		if err != nil {
+			t.Fatalf("%v: %v", test.filters, err)
+		}
+		if v := cf.match(testContainerRecord()); v != test.expected {
+			t.Errorf("%v: got %v, expected %v", test.filters, v, test.expected)
+		}
+	}
+}
+
+func TestParseContainerFilterInvalid(t *testing.T) {
+	for _, f := range []string{"name", "name=(", "port=http", "newer=1", "unknown=1"} {
+		if _, err := parseContainerFilter([]string{f}); err == nil {
+			t.Errorf("%s: expected an error", f)
+		}
+	}
+}
+
+func TestContainerFilterNeedAll(t *testing.T) {
+	data := []struct {
+		filters  []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"name=web"}, false},
+		{[]string{"status=exited"}, true},
+	}
+	for _, test := range data {
+		cf, err := parseContainerFilter(test.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := cf.needAll(); v != test.expected {
+			t.Errorf("%v: got %v, expected %v", test.filters, v, test.expected)
+		}
+	}
+}
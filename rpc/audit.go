@@ -0,0 +1,401 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"os/user"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/docker"
+	"github.com/yosisa/craft/mux"
+)
+
+// auditedMethods are mutating methods recorded in the audit log.
+var auditedMethods = map[string]bool{
+	"Craft.Submit":            true,
+	"Docker.StartContainer":   true,
+	"Docker.StopContainer":    true,
+	"Docker.RestartContainer": true,
+	"Docker.RemoveContainer":  true,
+	"Docker.RemoveImage":      true,
+	"Docker.LoadImage":        true,
+	"Docker.PullImage":        true,
+	"Docker.Exec":             true,
+	"Craft.SetSecret":         true,
+	"Craft.RemoveSecret":      true,
+}
+
+// secretKey matches names of environment variables whose values are not
+// written to the audit log.
+var secretKey = regexp.MustCompile(`(?i)pass|secret|token|key|credential|auth`)
+
+const redacted = "[REDACTED]"
+
+var errAuditLogClosed = errors.New("Audit log closed")
+
+// AuditRecord is a line of the audit log. ClaimedUser is what the client
+// asserted on connect (Hello.User or X-Craft-User), so it's not
+// authenticated.
+type AuditRecord struct {
+	Time        time.Time
+	Agent       string
+	Caller      string
+	ClaimedUser string
+	Method      string
+	Args        json.RawMessage
+	Duration    time.Duration
+	Error       string
+}
+
+// AuditRequest selects audit records. Users are matched against
+// ClaimedUser and Limit keeps the latest records only.
+type AuditRequest struct {
+	Since   int64
+	Until   int64
+	Methods []string
+	Users   []string
+	Match   string
+	Limit   int
+}
+
+func (r *AuditRequest) match(rec *AuditRecord) bool {
+	if r.Since > 0 && rec.Time.Unix() < r.Since {
+		return false
+	}
+	if r.Until > 0 && rec.Time.Unix() > r.Until {
+		return false
+	}
+	if len(r.Methods) > 0 && !matchMethod(r.Methods, rec.Method) {
+		return false
+	}
+	if len(r.Users) > 0 && !contains(r.Users, rec.ClaimedUser) {
+		return false
+	}
+	if r.Match != "" && !strings.Contains(string(rec.Args), r.Match) {
+		return false
+	}
+	return true
+}
+
+// matchMethod reports whether the method is one of methods, which may omit
+// the service name.
+func matchMethod(methods []string, method string) bool {
+	short := method[strings.Index(method, ".")+1:]
+	for _, m := range methods {
+		if m == method || m == short {
+			return true
+		}
+	}
+	return false
+}
+
+type AuditResponse struct {
+	Records []*AuditRecord
+}
+
+func (c *Craft) Audit(req AuditRequest, resp *AuditResponse) (err error) {
+	al := currentAuditor()
+	if al == nil {
+		return errors.New("Audit log is disabled")
+	}
+	resp.Records, err = al.query(&req)
+	return
+}
+
+var auditor *auditLog
+
+// audit records the call to the method if it's a mutating one.
+func audit(caller, user, method string, args interface{}, start time.Time, err error) {
+	al := currentAuditor()
+	if al == nil || !auditedMethods[method] {
+		return
+	}
+	name, _ := currentAgent()
+	rec := &AuditRecord{
+		Time:        start,
+		Agent:       name,
+		Caller:      caller,
+		ClaimedUser: user,
+		Method:      method,
+		Duration:    time.Since(start),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if b, err := json.Marshal(redactArgs(args)); err == nil {
+		rec.Args = b
+	}
+	if err := al.write(rec); err != nil {
+		log.WithField("error", err).Error("Failed to write audit log")
+	}
+}
+
+// redactArgs returns a copy of args without values of secret environment
+// variables and secrets.
+func redactArgs(args interface{}) interface{} {
+	if req, ok := args.(*SetSecretRequest); ok {
+		return &SetSecretRequest{Name: req.Name}
+	}
+	req, ok := args.(*SubmitRequest)
+	if !ok || req.Manifest == nil {
+		return args
+	}
+	m := *req.Manifest
+	m.Env = make(docker.Env, len(req.Manifest.Env))
+	for k, v := range req.Manifest.Env {
+		if secretKey.MatchString(k) {
+			v = redacted
+		}
+		m.Env[k] = v
+	}
+	r := *req
+	r.Manifest = &m
+	return &r
+}
+
+// auditLog appends records to a file in JSON lines. When the file exceeds
+// maxSize, it's renamed to path.1 and older ones are shifted up to
+// path.maxFiles.
+type auditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+	m        sync.Mutex
+}
+
+func openAuditLog(path string, maxSize int64, maxFiles int) (*auditLog, error) {
+	l := &auditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *auditLog) write(rec *AuditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.f == nil {
+		return errAuditLogClosed
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func (l *auditLog) close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func (l *auditLog) rotate() error {
+	l.f.Close()
+	l.f = nil
+	os.Remove(l.rotated(l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, l.rotated(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *auditLog) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// query returns records matching the request in order of time. Files are
+// read from the newest one and older ones are skipped once the limit is
+// reached.
+func (l *auditLog) query(req *AuditRequest) ([]*AuditRecord, error) {
+	var out []*AuditRecord
+	for i := 0; i <= l.maxFiles; i++ {
+		limit := 0
+		if req.Limit > 0 {
+			if limit = req.Limit - len(out); limit <= 0 {
+				break
+			}
+		}
+		path := l.path
+		if i > 0 {
+			path = l.rotated(i)
+		}
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		recs, err := readAuditRecords(f, req, limit)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		out = append(recs, out...)
+	}
+	return out, nil
+}
+
+// readAuditRecords returns the last limit records matching the request, or
+// all of them if limit is 0.
+func readAuditRecords(r io.Reader, req *AuditRequest, limit int) ([]*AuditRecord, error) {
+	var out []*AuditRecord
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			// a partial line may be left by a crash
+			continue
+		}
+		if !req.match(&rec) {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			copy(out, out[1:])
+			out = out[:limit-1]
+		}
+		out = append(out, &rec)
+	}
+	return out, s.Err()
+}
+
+var (
+	identities   = make(map[*mux.Session]string)
+	identitiesMu sync.Mutex
+)
+
+// setIdentity remembers the user told by the client for the session the
+// connection belongs to.
+func setIdentity(c net.Conn, user string) {
+	st, ok := c.(*mux.Stream)
+	if !ok || user == "" {
+		return
+	}
+	s := st.Session()
+	identitiesMu.Lock()
+	_, ok = identities[s]
+	identities[s] = user
+	identitiesMu.Unlock()
+	if !ok {
+		go func() {
+			<-s.Done()
+			identitiesMu.Lock()
+			delete(identities, s)
+			identitiesMu.Unlock()
+		}()
+	}
+}
+
+// identity returns the user of the session the connection belongs to.
+func identity(c io.ReadWriteCloser) string {
+	st, ok := c.(*mux.Stream)
+	if !ok {
+		return ""
+	}
+	identitiesMu.Lock()
+	defer identitiesMu.Unlock()
+	return identities[st.Session()]
+}
+
+// remoteAddr returns the address of the peer if known.
+func remoteAddr(c io.ReadWriteCloser) string {
+	if nc, ok := c.(net.Conn); ok {
+		return nc.RemoteAddr().String()
+	}
+	return ""
+}
+
+// currentUser returns user@host of the client.
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+// Audit returns audit records of agents merged in order of time.
+func Audit(addrs []string, req AuditRequest) ([]*AuditRecord, error) {
+	results, err := CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		if err := requireFeature(c, addr, FeatureAudit); err != nil {
+			return nil, err
+		}
+		var resp AuditResponse
+		err := c.Call("Craft.Audit", req, &resp)
+		return resp.Records, err
+	})
+	var out []*AuditRecord
+	for _, v := range results {
+		out = append(out, v.([]*AuditRecord)...)
+	}
+	sort.Stable(byAuditTime(out))
+	if req.Limit > 0 && len(out) > req.Limit {
+		out = out[len(out)-req.Limit:]
+	}
+	return out, err
+}
+
+type byAuditTime []*AuditRecord
+
+func (s byAuditTime) Len() int {
+	return len(s)
+}
+
+func (s byAuditTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byAuditTime) Less(i, j int) bool {
+	return s[i].Time.Before(s[j].Time)
+}
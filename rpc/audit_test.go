@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yosisa/craft/docker"
+	. "gopkg.in/check.v1"
+)
+
+type AuditSuite struct {
+	dir string
+}
+
+var _ = Suite(&AuditSuite{})
+
+func (s *AuditSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *AuditSuite) TearDownTest(c *C) {
+	auditor = nil
+}
+
+func (s *AuditSuite) TestRotate(c *C) {
+	path := filepath.Join(s.dir, "audit.log")
+	l, err := openAuditLog(path, 300, 2)
+	c.Assert(err, IsNil)
+	auditor = l
+	base := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		audit("127.0.0.1:1234", "alice@host", "Docker.StartContainer", &[]string{"web"}[0], base.Add(time.Duration(i)*time.Second), nil)
+	}
+	audit("127.0.0.1:1234", "bob@host", "Docker.RemoveContainer", &RemoveContainerRequest{ID: "db"}, base.Add(time.Minute), errors.New("No such container"))
+	audit("127.0.0.1:1234", "bob@host", "Docker.ListContainers", &ListContainersRequest{}, base, nil)
+
+	_, err = os.Stat(path + ".2")
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path + ".3")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(p)
+		c.Assert(err, IsNil)
+		c.Assert(fi.Size() <= 300, Equals, true)
+	}
+
+	recs, err := l.query(&AuditRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(len(recs) < 11, Equals, true)
+	for i := 1; i < len(recs); i++ {
+		c.Assert(recs[i].Time.Before(recs[i-1].Time), Equals, false)
+	}
+	last := recs[len(recs)-1]
+	c.Assert(last.Method, Equals, "Docker.RemoveContainer")
+	c.Assert(last.ClaimedUser, Equals, "bob@host")
+	c.Assert(last.Caller, Equals, "127.0.0.1:1234")
+	c.Assert(last.Error, Equals, "No such container")
+	c.Assert(string(last.Args), Equals, `{"ID":"db","Force":false}`)
+
+	recs, err = l.query(&AuditRequest{Methods: []string{"StartContainer"}, Limit: 2})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 2)
+	c.Assert(recs[1].Time.Equal(base.Add(9*time.Second)), Equals, true)
+	c.Assert(string(recs[1].Args), Equals, `"web"`)
+
+	recs, err = l.query(&AuditRequest{Users: []string{"bob@host"}, Since: base.Add(time.Minute).Unix()})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+	recs, err = l.query(&AuditRequest{Match: "db", Until: base.Add(time.Second).Unix()})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 0)
+}
+
+func (s *AuditSuite) TestQueryLimit(c *C) {
+	path := filepath.Join(s.dir, "audit.log")
+	l, err := openAuditLog(path, 300, 2)
+	c.Assert(err, IsNil)
+	base := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		rec := &AuditRecord{Time: base.Add(time.Duration(i) * time.Second), Method: "Docker.StartContainer"}
+		c.Assert(l.write(rec), IsNil)
+	}
+	// the oldest file is not read when newer ones have enough records
+	c.Assert(os.Remove(path+".2"), IsNil)
+	c.Assert(os.Mkdir(path+".2", 0700), IsNil)
+	recs, err := l.query(&AuditRequest{Limit: 3})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 3)
+	for i, rec := range recs {
+		c.Assert(rec.Time.Equal(base.Add(time.Duration(7+i)*time.Second)), Equals, true)
+	}
+	_, err = l.query(&AuditRequest{})
+	c.Assert(err, NotNil)
+}
+
+func (s *AuditSuite) TestPartialLine(c *C) {
+	path := filepath.Join(s.dir, "audit.log")
+	c.Assert(ioutil.WriteFile(path, []byte(`{"Method":"Docker.Exec"}`+"\n"+`{"Meth`), 0600), IsNil)
+	l, err := openAuditLog(path, 1024, 1)
+	c.Assert(err, IsNil)
+	recs, err := l.query(&AuditRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+}
+
+func (s *AuditSuite) TestRedactArgs(c *C) {
+	req := &SubmitRequest{Manifest: &docker.Manifest{
+		Name: "web",
+		Env:  docker.Env{"DB_PASSWORD": "pass", "API_TOKEN": "token", "PORT": "80"},
+	}}
+	r := redactArgs(req).(*SubmitRequest)
+	c.Assert(r.Manifest.Env, DeepEquals, docker.Env{"DB_PASSWORD": redacted, "API_TOKEN": redacted, "PORT": "80"})
+	c.Assert(r.Manifest.Name, Equals, "web")
+	c.Assert(req.Manifest.Env["DB_PASSWORD"], Equals, "pass")
+}
+
+func (s *AuditSuite) TestRedactSecret(c *C) {
+	r := redactArgs(&SetSecretRequest{Name: "db", Value: []byte("encrypted")}).(*SetSecretRequest)
+	c.Assert(r.Name, Equals, "db")
+	c.Assert(r.Value, IsNil)
+}
@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -9,23 +10,47 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/nsf/termbox-go"
 	"github.com/pierrec/lz4"
 	"github.com/yosisa/craft/mux"
 	"github.com/yosisa/throttle"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// AllocStream opens a stream to the agent, whose id is passed to RPC calls
+// using it. The stream is opened in the session and the agent tells the id,
+// or older agents allocate the id by RPC first.
 func AllocStream(c *rpc.Client, addr string) (id uint32, conn net.Conn, err error) {
+	sessionsMu.Lock()
+	s := sessions[c]
+	sessionsMu.Unlock()
+	if s != nil && agentHello(c).Supports(FeatureOpenStream) {
+		if conn, err = s.Dial(chanOpenStream); err != nil {
+			return
+		}
+		if err = binary.Read(conn, binary.BigEndian, &id); err != nil {
+			conn.Close()
+		}
+		return
+	}
+
 	var resp AllocResponse
 	if err = c.Call("StreamConn.Alloc", Empty{}, &resp); err != nil {
 		return
 	}
 	id = resp.ID
-	if conn, err = mux.DialTimeout("tcp", addr, chanNewStream, dialTimeout); err != nil {
+	if s != nil {
+		conn, err = s.Dial(chanNewStream)
+	} else {
+		conn, err = mux.DialTimeout("tcp", addr, chanNewStream, dialTimeout)
+	}
+	if err != nil {
 		return
 	}
 	err = binary.Write(conn, binary.BigEndian, id)
@@ -167,6 +192,243 @@ func Logs(addrs []string, container string, follow bool, tail string) error {
 	return err
 }
 
+const (
+	eventHoldTime      = time.Second
+	eventReconnectWait = 30 * time.Second
+)
+
+func Events(addrs []string, req EventsRequest) {
+	closed := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		signal.Stop(sig)
+		close(closed)
+	}()
+
+	m := newEventMerger(eventHoldTime, func(ev *Event) {
+		name := ev.Name
+		if name == "" {
+			name = ev.ID
+			if len(name) > 12 {
+				name = name[:12]
+			}
+		}
+		fmt.Printf("%s [%s] %s %s\n", time.Unix(ev.Time, 0).Format(time.RFC3339),
+			ShortHostname(ev.addr, true), name, ev.Status)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			defer wg.Done()
+			wait := time.Second
+			cur := &eventCursor{since: req.Since}
+			if cur.since == 0 {
+				cur.since = time.Now().Unix()
+			}
+			for {
+				start := time.Now()
+				err := watchEvents(addr, req, cur, m, closed)
+				select {
+				case <-closed:
+					return
+				default:
+				}
+				if _, ok := err.(*FeatureError); ok {
+					log.WithField("error", err).Error("Failed to watch events")
+					return
+				}
+				if req.Until > 0 && time.Now().Unix() >= req.Until {
+					return
+				}
+				if time.Since(start) > eventReconnectWait {
+					wait = time.Second
+				}
+				fields := log.Fields{"agent": addr, "retry": wait}
+				if err != nil {
+					fields["error"] = err
+				}
+				log.WithFields(fields).Warning("Lost event stream, reconnecting")
+				select {
+				case <-time.After(wait):
+				case <-closed:
+					return
+				}
+				if wait *= 2; wait > eventReconnectWait {
+					wait = eventReconnectWait
+				}
+				// resume from the last event to get ones missed meanwhile
+				req.Since = cur.since
+			}
+		}(addr)
+	}
+	wg.Wait()
+	m.close()
+}
+
+// eventCursor remembers the position of an event stream. Events at the same
+// second as the last one may be received again after reconnecting, so they
+// are skipped by their ID and status.
+type eventCursor struct {
+	since int64
+	seen  map[string]bool
+}
+
+// advance moves the cursor to the event and reports whether it's new.
+func (c *eventCursor) advance(ev *Event) bool {
+	if ev.Time > c.since {
+		c.since = ev.Time
+		c.seen = nil
+	}
+	if ev.Time < c.since {
+		return true
+	}
+	key := ev.ID + " " + ev.Status
+	if c.seen[key] {
+		return false
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	c.seen[key] = true
+	return true
+}
+
+func watchEvents(addr string, req EventsRequest, cur *eventCursor, m *eventMerger, closed chan struct{}) error {
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = requireFeature(c, addr, FeatureEvents); err != nil {
+		return err
+	}
+	id, sc, err := AllocStream(c, addr)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	req.StreamID = id
+	call := c.Go("Docker.Events", req, &Empty{}, nil)
+
+	errc := make(chan error, 1)
+	go func() {
+		dec := json.NewDecoder(sc)
+		for {
+			var ev Event
+			if err := dec.Decode(&ev); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+			if !cur.advance(&ev) {
+				continue
+			}
+			ev.addr = addr
+			m.add(&ev)
+		}
+	}()
+
+	select {
+	case err = <-errc:
+	case <-closed:
+		// make sure no more events are added to the merger
+		sc.Close()
+		<-errc
+		return nil
+	}
+	if call := <-call.Done; call.Error != nil {
+		return call.Error
+	}
+	return err
+}
+
+func Stats(addrs []string, containers []string) (map[string]interface{}, error) {
+	return CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		var stats []*ContainerStats
+		err := readStats(c, addr, containers, false, func(s *ContainerStats) {
+			stats = append(stats, s)
+		})
+		return stats, err
+	})
+}
+
+func StreamStats(addrs []string, containers []string) error {
+	if err := termbox.Init(); err != nil {
+		return err
+	}
+	t := newStatsTable()
+	go t.show()
+
+	var m sync.Mutex
+	var cs []*rpc.Client
+	go func() {
+		for {
+			ev := termbox.PollEvent()
+			if ev.Type == termbox.EventKey &&
+				(ev.Key == termbox.KeyCtrlC || ev.Key == termbox.KeyEsc || ev.Ch == 'q') {
+				break
+			}
+		}
+		m.Lock()
+		defer m.Unlock()
+		for _, c := range cs {
+			c.Close()
+		}
+		cs = nil
+	}()
+
+	_, err := CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		m.Lock()
+		cs = append(cs, c)
+		m.Unlock()
+		err := readStats(c, addr, containers, true, func(s *ContainerStats) {
+			t.add(addr, s)
+		})
+		if err == rpc.ErrShutdown {
+			err = nil
+		}
+		return nil, err
+	})
+	t.close()
+	termbox.Close()
+	return err
+}
+
+func readStats(c *rpc.Client, addr string, containers []string, stream bool, f func(*ContainerStats)) error {
+	if err := requireFeature(c, addr, FeatureStats); err != nil {
+		return err
+	}
+	id, sc, err := AllocStream(c, addr)
+	if err != nil {
+		return err
+	}
+	defer sc.Close()
+	req := StatsRequest{Containers: containers, Stream: stream, StreamID: id}
+	errc := make(chan error, 1)
+	go func() {
+		// release the stream also when the rpc client is closed
+		call := <-c.Go("Docker.Stats", req, &Empty{}, nil).Done
+		sc.Close()
+		errc <- call.Error
+	}()
+
+	dec := json.NewDecoder(sc)
+	for {
+		var s ContainerStats
+		if err := dec.Decode(&s); err != nil {
+			break
+		}
+		f(&s)
+	}
+	return <-errc
+}
+
 func ListImages(addrs []string) (map[string]interface{}, error) {
 	return CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
 		var resp ListImagesResponse
@@ -175,6 +437,26 @@ func ListImages(addrs []string) (map[string]interface{}, error) {
 	})
 }
 
+// Members returns the member list of the first agent which answers.
+func Members(addrs []string) ([]Member, error) {
+	var err error
+	for _, addr := range addrs {
+		var c *rpc.Client
+		if c, err = Dial("tcp", addr); err != nil {
+			continue
+		}
+		var resp MembersResponse
+		if err = requireFeature(c, addr, FeatureMembers); err == nil {
+			err = c.Call("Craft.Members", Empty{}, &resp)
+		}
+		c.Close()
+		if err == nil {
+			return resp.Members, nil
+		}
+	}
+	return nil, err
+}
+
 func LoadImage(addrs []string, r io.Reader, compress bool, bwlimit uint64) error {
 	n := int32(len(addrs))
 	queue := make(chan net.Conn, len(addrs))
@@ -266,6 +548,48 @@ func RemoveImage(addrs []string, name string) error {
 	return err
 }
 
+func BuildImage(addr string, r io.Reader, req BuildImageRequest) error {
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if err = requireFeature(c, addr, FeatureBuild); err != nil {
+		return err
+	}
+
+	inid, inc, err := AllocStream(c, addr)
+	if err != nil {
+		return err
+	}
+	outid, outc, err := AllocStream(c, addr)
+	if err != nil {
+		inc.Close()
+		return err
+	}
+	dst := newAtomicWriter(os.Stdout)
+	go dst.read(fmt.Sprintf("[%s] ", ShortHostname(addr, true)), outc)
+
+	go func(w io.Writer) {
+		defer inc.Close()
+		if req.Compress {
+			w = lz4.NewWriter(w)
+			defer w.(*lz4.Writer).Close()
+		}
+		io.Copy(w, r)
+	}(inc)
+
+	req.InStreamID = inid
+	req.OutStreamID = outid
+	err = c.Call("Docker.BuildImage", req, &Empty{})
+	dst.wait()
+	if err == nil {
+		fields := log.Fields{"agent": addr, "image": req.Name}
+		log.WithFields(fields).Info("Image built")
+	}
+	return err
+}
+
 func Exec(addrs []string, container string, cmd []string, interactive, tty bool) error {
 	var w, h int
 	if tty {
@@ -1,8 +1,16 @@
 package rpc
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/fsouza/go-dockerclient"
@@ -11,7 +19,8 @@ import (
 )
 
 type Docker struct {
-	c *docker.Client
+	c        *docker.Client
+	endpoint string
 }
 
 func NewDocker(endpoint string) (*Docker, error) {
@@ -19,7 +28,7 @@ func NewDocker(endpoint string) (*Docker, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Docker{c: c}, nil
+	return &Docker{c: c, endpoint: endpoint}, nil
 }
 
 type ListContainersRequest struct {
@@ -96,17 +105,23 @@ type PullImageRequest struct {
 	StreamID uint32
 }
 
-func (d *Docker) PullImage(req PullImageRequest, resp *Empty) error {
+func (d *Docker) PullImage(req PullImageRequest, resp *Empty) (err error) {
+	if err = beginOperation(); err != nil {
+		return err
+	}
+	defer endOperation()
 	w, err := streamConn.get(req.StreamID)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
+	start := time.Now()
+	defer func() { observeImage("pull", start, err) }()
 	image, tag := cdocker.SplitImageTag(req.Image)
 	opts := docker.PullImageOptions{
 		Repository:    image,
 		Tag:           tag,
-		OutputStream:  w,
+		OutputStream:  newPullCounter(w),
 		RawJSONStream: true,
 	}
 	auth := docker.AuthConfiguration{}
@@ -158,17 +173,24 @@ type LoadImageRequest struct {
 	Rest     []string
 }
 
-func (d *Docker) LoadImage(req LoadImageRequest, resp *Empty) error {
+func (d *Docker) LoadImage(req LoadImageRequest, resp *Empty) (err error) {
+	if err = beginOperation(); err != nil {
+		return err
+	}
+	defer endOperation()
 	c, err := streamConn.get(req.StreamID)
 	if err != nil {
 		return err
 	}
+	start := time.Now()
+	defer func() { observeImage("load", start, err) }()
+	loaded := imageBytes.WithLabelValues("load")
 	var r io.Reader = c
 	if len(req.Rest) == 0 {
 		if req.Compress {
 			r = lz4.NewReader(r)
 		}
-		return d.c.LoadImage(docker.LoadImageOptions{InputStream: r})
+		return d.c.LoadImage(docker.LoadImageOptions{InputStream: &countingReader{r, loaded}})
 	}
 
 	// pipelining and is intermediate node
@@ -182,7 +204,7 @@ func (d *Docker) LoadImage(req LoadImageRequest, resp *Empty) error {
 		errc <- connectImagePipeline(req.Rest, pr, req.Compress)
 	}()
 	go func() {
-		errc <- d.c.LoadImage(docker.LoadImageOptions{InputStream: r})
+		errc <- d.c.LoadImage(docker.LoadImageOptions{InputStream: &countingReader{r, loaded}})
 		pw.Close()
 	}()
 
@@ -200,6 +222,279 @@ func (d *Docker) RemoveImage(req string, resp *Empty) error {
 	return d.c.RemoveImage(req)
 }
 
+type BuildImageRequest struct {
+	Name        string
+	Dockerfile  string
+	NoCache     bool
+	Pull        bool
+	Compress    bool
+	Rest        []string
+	InStreamID  uint32
+	OutStreamID uint32
+}
+
+func (d *Docker) BuildImage(req BuildImageRequest, resp *Empty) error {
+	if err := beginOperation(); err != nil {
+		return err
+	}
+	defer endOperation()
+	in, err := streamConn.get(req.InStreamID)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := streamConn.get(req.OutStreamID)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var r io.Reader = in
+	if req.Compress {
+		r = lz4.NewReader(r)
+	}
+	err = d.c.BuildImage(docker.BuildImageOptions{
+		Name:           req.Name,
+		Dockerfile:     req.Dockerfile,
+		NoCache:        req.NoCache,
+		Pull:           req.Pull,
+		RmTmpContainer: true,
+		InputStream:    r,
+		OutputStream:   out,
+	})
+	if err != nil || len(req.Rest) == 0 {
+		return err
+	}
+
+	// distribute the built image to the rest of agents using pipeline
+	fmt.Fprintf(out, "Distributing %s to %d agents\n", req.Name, len(req.Rest))
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(d.c.ExportImage(docker.ExportImageOptions{
+			Name:         req.Name,
+			OutputStream: pw,
+		}))
+	}()
+	err = LoadImageUsingPipeline(req.Rest, pr, req.Compress, 0)
+	pr.CloseWithError(err)
+	return err
+}
+
+type EventsRequest struct {
+	Names    []string
+	Statuses []string
+	Since    int64
+	Until    int64
+	StreamID uint32
+}
+
+func (r *EventsRequest) match(ev *Event) bool {
+	if r.Since > 0 && ev.Time < r.Since {
+		return false
+	}
+	if r.Until > 0 && ev.Time > r.Until {
+		return false
+	}
+	if len(r.Names) > 0 && !contains(r.Names, ev.Name) {
+		return false
+	}
+	if len(r.Statuses) > 0 && !contains(r.Statuses, ev.Status) {
+		return false
+	}
+	return true
+}
+
+func (d *Docker) Events(req EventsRequest, resp *Empty) error {
+	w, err := streamConn.get(req.StreamID)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	listener := make(chan *docker.APIEvents, 16)
+	added := time.Now().Unix()
+	if err = d.c.AddEventListener(listener); err != nil {
+		return err
+	}
+	defer d.c.RemoveEventListener(listener)
+
+	// the client never writes to the stream, so reading returns when it hangs up
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, w)
+		close(closed)
+	}()
+	var until <-chan time.Time
+	if req.Until > 0 {
+		until = time.After(time.Unix(req.Until, 0).Sub(time.Now()))
+	}
+
+	names := make(map[string]string)
+	enc := json.NewEncoder(w)
+	send := func(e *docker.APIEvents) error {
+		name, ok := names[e.ID]
+		if !ok {
+			if con, err := d.c.InspectContainer(e.ID); err == nil {
+				name = strings.TrimPrefix(con.Name, "/")
+			}
+			names[e.ID] = name
+		}
+		ev := &Event{Time: e.Time, Status: e.Status, ID: e.ID, Name: name, From: e.From}
+		if !req.match(ev) {
+			return nil
+		}
+		return enc.Encode(ev)
+	}
+
+	// past events are sent first. The listener was added before fetching
+	// them, so events since then may come from both.
+	sent := make(map[string]bool)
+	if req.Since > 0 && req.Since <= added {
+		end := time.Now().Unix()
+		if req.Until > 0 && req.Until < end {
+			end = req.Until
+		}
+		history, err := dockerEvents(d.endpoint, req.Since, end)
+		if err != nil {
+			return err
+		}
+		for _, e := range history {
+			if e.Time >= added {
+				sent[eventKey(e)] = true
+			}
+			if err = send(e); err != nil {
+				return nil
+			}
+		}
+	}
+	if req.Until > 0 && req.Until < added {
+		// nothing to wait for
+		return nil
+	}
+	for {
+		select {
+		case e, ok := <-listener:
+			if !ok {
+				return nil
+			}
+			if sent[eventKey(e)] {
+				continue
+			}
+			if err = send(e); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		case <-until:
+			return nil
+		}
+	}
+}
+
+func eventKey(e *docker.APIEvents) string {
+	return fmt.Sprintf("%d %s %s", e.Time, e.ID, e.Status)
+}
+
+// dockerEvents returns events in the range from the endpoint, which
+// go-dockerclient supports only as a live stream.
+func dockerEvents(endpoint string, since, until int64) ([]*docker.APIEvents, error) {
+	client, base, err := dockerHTTP(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/events?since=%d&until=%d", base, since, until))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get events: %s", resp.Status)
+	}
+	var out []*docker.APIEvents
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e docker.APIEvents
+		if err := dec.Decode(&e); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		out = append(out, &e)
+	}
+}
+
+// dockerHTTP returns an HTTP client and the base URL of the endpoint.
+func dockerHTTP(endpoint string) (*http.Client, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		tr := &http.Transport{Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", path)
+		}}
+		return &http.Client{Transport: tr}, "http://docker", nil
+	case "tcp", "http":
+		return http.DefaultClient, "http://" + u.Host, nil
+	}
+	return nil, "", fmt.Errorf("Unsupported docker endpoint: %s", endpoint)
+}
+
+type StatsRequest struct {
+	Containers []string
+	Stream     bool
+	StreamID   uint32
+}
+
+func (d *Docker) Stats(req StatsRequest, resp *Empty) error {
+	w, err := streamConn.get(req.StreamID)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	var lc ListContainersResponse
+	if err = d.ListContainers(ListContainersRequest{}, &lc); err != nil {
+		return err
+	}
+	done := make(chan bool)
+	if req.Stream {
+		// the client never writes to the stream, so reading returns when it hangs up
+		go func() {
+			io.Copy(ioutil.Discard, w)
+			close(done)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	enc := json.NewEncoder(w)
+	for _, con := range lc.FilterByNames(req.Containers) {
+		wg.Add(1)
+		go func(id, name string) {
+			defer wg.Done()
+			c := make(chan *docker.Stats)
+			go func() {
+				opts := docker.StatsOptions{ID: id, Stats: c, Stream: req.Stream, Done: done}
+				if err := d.c.Stats(opts); err != nil {
+					log.WithFields(log.Fields{"error": err, "container": name}).Error("Failed to get stats")
+				}
+			}()
+			var prev *docker.Stats
+			for s := range c {
+				m.Lock()
+				enc.Encode(newContainerStats(id, name, s, prev))
+				m.Unlock()
+				prev = s
+			}
+		}(con.ID, cdocker.CanonicalName(con.Names))
+	}
+	wg.Wait()
+	return nil
+}
+
 type ExecRequest struct {
 	Container   string
 	Cmd         []string
@@ -0,0 +1,96 @@
+package rpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Event struct {
+	Time   int64
+	Status string
+	ID     string
+	Name   string
+	From   string
+	addr   string
+}
+
+// eventMerger reorders events coming from several agents by their time.
+// Events are held for a while since they may arrive out of order.
+type eventMerger struct {
+	c     chan *Event
+	hold  time.Duration
+	out   func(*Event)
+	queue []*Event
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newEventMerger(hold time.Duration, out func(*Event)) *eventMerger {
+	m := &eventMerger{
+		c:    make(chan *Event, 64),
+		hold: hold,
+		out:  out,
+		done: make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *eventMerger) add(ev *Event) {
+	m.c <- ev
+}
+
+func (m *eventMerger) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.hold / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-m.c:
+			if !ok {
+				m.flush(time.Time{})
+				return
+			}
+			// keep the queue sorted; equal times preserve arrival order
+			i := sort.Search(len(m.queue), func(i int) bool {
+				return m.queue[i].Time > ev.Time
+			})
+			m.queue = append(m.queue, nil)
+			copy(m.queue[i+1:], m.queue[i:])
+			m.queue[i] = ev
+		case now := <-ticker.C:
+			m.flush(now.Add(-m.hold))
+		}
+	}
+}
+
+// flush outputs events that happened before the deadline. A zero deadline
+// flushes all events.
+func (m *eventMerger) flush(deadline time.Time) {
+	var n int
+	for _, ev := range m.queue {
+		if !deadline.IsZero() && time.Unix(ev.Time, 0).After(deadline) {
+			break
+		}
+		m.out(ev)
+		n++
+	}
+	m.queue = m.queue[n:]
+}
+
+func (m *eventMerger) close() {
+	m.once.Do(func() {
+		close(m.c)
+	})
+	<-m.done
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventMerger(t *testing.T) {
+	var out []*Event
+	m := newEventMerger(time.Hour, func(ev *Event) {
+		out = append(out, ev)
+	})
+	for _, ev := range []*Event{
+		{Time: 3, Status: "die", addr: "a"},
+		{Time: 1, Status: "create", addr: "b"},
+		{Time: 3, Status: "start", addr: "b"},
+		{Time: 2, Status: "start", addr: "a"},
+	} {
+		m.add(ev)
+	}
+	m.close()
+
+	expected := []string{"create", "start", "die", "start"}
+	if len(out) != len(expected) {
+		t.Fatalf("got %d events, expected %d", len(out), len(expected))
+	}
+	for i, ev := range out {
+		if ev.Status != expected[i] {
+			t.Errorf("index %d failed: got %s, expected %s", i, ev.Status, expected[i])
+		}
+	}
+}
+
+func TestEventsRequestMatch(t *testing.T) {
+	data := []struct {
+		req      EventsRequest
+		ev       Event
+		expected bool
+	}{
+		{EventsRequest{}, Event{Name: "api", Status: "start"}, true},
+		{EventsRequest{Names: []string{"api"}}, Event{Name: "api"}, true},
+		{EventsRequest{Names: []string{"api"}}, Event{Name: "db"}, false},
+		{EventsRequest{Statuses: []string{"die", "stop"}}, Event{Status: "stop"}, true},
+		{EventsRequest{Statuses: []string{"die", "stop"}}, Event{Status: "start"}, false},
+		{EventsRequest{Since: 10}, Event{Time: 9}, false},
+		{EventsRequest{Since: 10}, Event{Time: 10}, true},
+		{EventsRequest{Until: 20}, Event{Time: 20}, true},
+		{EventsRequest{Until: 20}, Event{Time: 21}, false},
+		{EventsRequest{Since: 10, Until: 20}, Event{Time: 15}, true},
+		{EventsRequest{Since: 10, Until: 20}, Event{Time: 25}, false},
+	}
+	for i, test := range data {
+		if v := test.req.match(&test.ev); v != test.expected {
+			t.Errorf("index %d failed: got %v, expected %v", i, v, test.expected)
+		}
+	}
+}
+
+func TestEventCursor(t *testing.T) {
+	cur := &eventCursor{since: 10}
+	data := []struct {
+		ev       Event
+		expected bool
+	}{
+		{Event{Time: 10, ID: "a", Status: "start"}, true},
+		{Event{Time: 10, ID: "a", Status: "start"}, false},
+		{Event{Time: 10, ID: "a", Status: "die"}, true},
+		{Event{Time: 11, ID: "a", Status: "start"}, true},
+		{Event{Time: 11, ID: "a", Status: "die"}, true},
+		{Event{Time: 11, ID: "a", Status: "die"}, false},
+		{Event{Time: 10, ID: "b", Status: "start"}, true},
+	}
+	for i, test := range data {
+		if v := cur.advance(&test.ev); v != test.expected {
+			t.Errorf("index %d failed: got %v, expected %v", i, v, test.expected)
+		}
+	}
+	if cur.since != 11 {
+		t.Errorf("got since %d, expected 11", cur.since)
+	}
+}
+
+func TestDockerEvents(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprintln(w, `{"status":"create","id":"a","from":"redis","time":10}`)
+		fmt.Fprintln(w, `{"status":"start","id":"a","from":"redis","time":11}`)
+	}))
+	defer ts.Close()
+
+	events, err := dockerEvents(strings.Replace(ts.URL, "http://", "tcp://", 1), 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "since=10&until=20" {
+		t.Errorf("got query %q", query)
+	}
+	if len(events) != 2 || events[0].Status != "create" || events[1].Time != 11 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+
+	if _, err = dockerEvents("ssh://example.com", 10, 20); err == nil {
+		t.Error("expected an error for an unsupported endpoint")
+	}
+}
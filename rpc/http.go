@@ -0,0 +1,577 @@
+package rpc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	errNotFound         = errors.New("Not found")
+	errMethodNotAllowed = errors.New("Method not allowed")
+	errUnauthorized     = errors.New("Unauthorized")
+	errCrossOrigin      = errors.New("Cross-origin request forbidden")
+	errNotJSON          = errors.New("Content-Type must be application/json")
+)
+
+// Gateway exposes the Craft and Docker services as JSON over HTTP. It calls
+// the same methods as RPC does, so requests are serialized in the same way.
+//
+// Streaming endpoints (submit, logs and non-interactive exec) respond with
+// JSON lines. Output is sent as {"stream": "stdout", "data": "..."} as it
+// comes, where the stream is stdout or stderr. The last line has no stream
+// and is either the result or {"error": "..."} if it failed after some output
+// is sent, when the status can't be changed anymore.
+//
+// Interactive exec is a WebSocket request of GET with the command in the
+// query, e.g. ?cmd=sh&cmd=-l&tty=true&width=80&height=24. Messages from the
+// client are written to stdin. Output is sent in binary messages whose first
+// byte tells the stream, 1 for stdout and 2 for stderr. The connection is
+// closed with status 1000 on success, or 1011 and the error as the reason.
+//
+// Like RPC, the gateway doesn't authorize requests unless a token is given,
+// which is then required as "Authorization: Bearer <token>". Requests which
+// change something and WebSocket upgrades are rejected if the Origin header
+// tells another site, and request bodies must be application/json, so that
+// web pages can't make browsers call the gateway.
+type Gateway struct {
+	craft  *Craft
+	docker *Docker
+	token  string
+}
+
+func NewGateway(craft *Craft, docker *Docker, token string) *Gateway {
+	return &Gateway{craft: craft, docker: docker, token: token}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Debug("HTTP request")
+	if err := g.authorize(r); err != nil {
+		writeError(w, err)
+		return
+	}
+	var err error
+	switch path := strings.TrimSuffix(r.URL.Path, "/"); {
+	case path == "/v1/capability":
+		err = g.capability(w, r)
+	case path == "/v1/containers":
+		err = g.containers(w, r)
+	case path == "/v1/images":
+		err = g.images(w, r)
+	case path == "/v1/submit":
+		err = g.submit(w, r)
+	case strings.HasPrefix(path, "/v1/containers/"):
+		name, action := splitContainerPath(path[len("/v1/containers/"):])
+		err = g.container(w, r, name, action)
+	default:
+		err = errNotFound
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (g *Gateway) authorize(r *http.Request) error {
+	if g.token != "" {
+		expected := "Bearer " + g.token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			return errUnauthorized
+		}
+	}
+	if (r.Method != "GET" && r.Method != "HEAD" || isWebSocket(r)) && !sameOrigin(r) {
+		return errCrossOrigin
+	}
+	return nil
+}
+
+// sameOrigin reports whether the request comes from a page of the gateway
+// itself, or not from browsers at all since they always send Origin in
+// cross-origin requests.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// decodeJSON reads the request body, which must be application/json.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		return errNotJSON
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// splitContainerPath splits "name/action" into its parts.
+func splitContainerPath(s string) (name, action string) {
+	if n := strings.Index(s, "/"); n >= 0 {
+		return s[:n], s[n+1:]
+	}
+	return s, ""
+}
+
+func (g *Gateway) capability(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed
+	}
+	var resp Capability
+	if err := g.craft.Capability(Empty{}, &resp); err != nil {
+		return err
+	}
+	return writeJSON(w, &resp)
+}
+
+func (g *Gateway) containers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed
+	}
+	req := ListContainersRequest{All: queryBool(r, "all")}
+	var resp ListContainersResponse
+	if err := g.docker.ListContainers(req, &resp); err != nil {
+		return err
+	}
+	return writeJSON(w, &resp)
+}
+
+func (g *Gateway) images(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed
+	}
+	var resp ListImagesResponse
+	if err := g.docker.ListImages(Empty{}, &resp); err != nil {
+		return err
+	}
+	return writeJSON(w, &resp)
+}
+
+// containerActions maps actions on a container to the allowed method.
+var containerActions = map[string]string{
+	"":        "DELETE",
+	"start":   "POST",
+	"stop":    "POST",
+	"restart": "POST",
+	"logs":    "GET",
+	"exec":    "POST",
+}
+
+func (g *Gateway) container(w http.ResponseWriter, r *http.Request, name, action string) error {
+	if name == "" {
+		return errNotFound
+	}
+	timeout, err := queryUint(r, "timeout", 10)
+	if err != nil {
+		return err
+	}
+	method, ok := containerActions[action]
+	if !ok {
+		return errNotFound
+	}
+	if r.Method != method && !(action == "exec" && isWebSocket(r)) {
+		return errMethodNotAllowed
+	}
+
+	var call string
+	var args interface{}
+	start := time.Now()
+	switch action {
+	case "":
+		req := RemoveContainerRequest{ID: name, Force: queryBool(r, "force")}
+		call, args, err = "Docker.RemoveContainer", &req, g.docker.RemoveContainer(req, &Empty{})
+	case "start":
+		call, args, err = "Docker.StartContainer", &name, g.docker.StartContainer(name, &Empty{})
+	case "stop":
+		req := StopContainerRequest{ID: name, Timeout: timeout}
+		call, args, err = "Docker.StopContainer", &req, g.docker.StopContainer(req, &Empty{})
+	case "restart":
+		req := RestartContainerRequest{ID: name, Timeout: timeout}
+		call, args, err = "Docker.RestartContainer", &req, g.docker.RestartContainer(req, &Empty{})
+	case "logs":
+		return g.logs(w, r, name)
+	case "exec":
+		return g.exec(w, r, name)
+	}
+	g.audit(r, call, args, start, err)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &Empty{})
+}
+
+// audit records the call in the audit log. The user is told by the client
+// in the X-Craft-User header.
+func (g *Gateway) audit(r *http.Request, method string, args interface{}, start time.Time, err error) {
+	audit(r.RemoteAddr, r.Header.Get("X-Craft-User"), method, args, start, err)
+}
+
+func (g *Gateway) submit(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return errMethodNotAllowed
+	}
+	var req SubmitRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if req.Manifest == nil {
+		return errors.New("Manifest required")
+	}
+	if err := req.Manifest.Validate(); err != nil {
+		return err
+	}
+
+	return g.stream(w, r, []string{"stdout"}, func(ids []uint32) (interface{}, error) {
+		var resp SubmitResponse
+		req.StreamID = ids[0]
+		start := time.Now()
+		err := g.craft.Submit(req, &resp)
+		g.audit(r, "Craft.Submit", &req, start, err)
+		return &resp, err
+	})
+}
+
+func (g *Gateway) logs(w http.ResponseWriter, r *http.Request, name string) error {
+	return g.stream(w, r, []string{"stdout", "stderr"}, func(ids []uint32) (interface{}, error) {
+		req := LogsRequest{
+			Container:   name,
+			Follow:      queryBool(r, "follow"),
+			Tail:        r.URL.Query().Get("tail"),
+			OutStreamID: ids[0],
+			ErrStreamID: ids[1],
+		}
+		return nil, g.docker.Logs(req, &Empty{})
+	})
+}
+
+func (g *Gateway) exec(w http.ResponseWriter, r *http.Request, name string) error {
+	if isWebSocket(r) {
+		return g.interactiveExec(w, r, name)
+	}
+	var req ExecRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return err
+	}
+	if len(req.Cmd) == 0 {
+		return errors.New("Cmd required")
+	}
+	if req.Interactive {
+		return errWebSocketRequired
+	}
+	req.Container = name
+	return g.stream(w, r, []string{"stdout", "stderr"}, func(ids []uint32) (interface{}, error) {
+		req.OutStreamID, req.ErrStreamID = ids[0], ids[1]
+		start := time.Now()
+		err := g.docker.Exec(req, &Empty{})
+		g.audit(r, "Docker.Exec", &req, start, err)
+		return nil, err
+	})
+}
+
+func (g *Gateway) interactiveExec(w http.ResponseWriter, r *http.Request, name string) error {
+	req := ExecRequest{
+		Container:   name,
+		Cmd:         r.URL.Query()["cmd"],
+		Interactive: true,
+		TTY:         queryBool(r, "tty"),
+	}
+	if len(req.Cmd) == 0 {
+		return errors.New("Cmd required")
+	}
+	width, err := queryUint(r, "width", 0)
+	if err != nil {
+		return err
+	}
+	height, err := queryUint(r, "height", 0)
+	if err != nil {
+		return err
+	}
+	req.TTYWidth, req.TTYHeight = int(width), int(height)
+
+	ids, cs, err := localStreams(3)
+	if err != nil {
+		return err
+	}
+	defer closeAll(cs)
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		for _, id := range ids {
+			streamConn.release(id)
+		}
+		return err
+	}
+
+	req.OutStreamID, req.ErrStreamID, req.InStreamID = ids[0], ids[1], ids[2]
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i, c := range cs[:2] {
+		go func(stream byte, c net.Conn) {
+			defer wg.Done()
+			copyMessages(ws, stream, c)
+			c.Close()
+		}(byte(i+1), c)
+	}
+	go func() {
+		for {
+			_, p, err := ws.ReadMessage()
+			if err != nil {
+				// stdin reaches EOF
+				cs[2].Close()
+				return
+			}
+			if _, err = cs[2].Write(p); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	err = g.docker.Exec(req, &Empty{})
+	g.audit(r, "Docker.Exec", &req, start, err)
+	for _, id := range ids {
+		streamConn.release(id)
+	}
+	wg.Wait()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "container": name}).Error("Failed to exec")
+		ws.Close(wsCloseInternal, err.Error())
+	} else {
+		ws.Close(wsCloseNormal, "")
+	}
+	// the response is taken over by the WebSocket
+	return nil
+}
+
+// copyMessages sends what are read from r in binary messages prefixed by
+// the stream.
+func copyMessages(ws *wsConn, stream byte, r io.Reader) error {
+	buf := make([]byte, 32*1024)
+	buf[0] = stream
+	for {
+		n, err := r.Read(buf[1:])
+		if n > 0 {
+			if werr := ws.WriteMessage(wsBinary, buf[:n+1]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// stream calls f with a local stream for each name and writes what are
+// written to the streams to the response as frames as they come. The result
+// of f is written as the last line. The status is sent with the first output,
+// so an error of f before that is returned as is.
+func (g *Gateway) stream(w http.ResponseWriter, r *http.Request, names []string, f func([]uint32) (interface{}, error)) error {
+	ids, cs, err := localStreams(len(names))
+	if err != nil {
+		return err
+	}
+	defer closeAll(cs)
+
+	fw := &lockedWriter{w: w, header: func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}}
+	if fl, ok := w.(http.Flusher); ok {
+		fw.f = fl
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(cs))
+	for i, c := range cs {
+		go func(name string, c net.Conn) {
+			defer wg.Done()
+			copyFrames(fw, name, c)
+			// let the writer fail if the client hangs up
+			c.Close()
+		}(names[i], c)
+	}
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed := cn.CloseNotify()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-closed:
+				closeAll(cs)
+			case <-done:
+			}
+		}()
+	}
+
+	v, err := f(ids)
+	// streams f didn't take would be open until they expire
+	for _, id := range ids {
+		streamConn.release(id)
+	}
+	wg.Wait()
+	if err != nil {
+		if !fw.started() {
+			return err
+		}
+		v = map[string]string{"error": err.Error()}
+	}
+	if v == nil {
+		// send the status even if there is no output
+		_, err = fw.Write(nil)
+		return err
+	}
+	return json.NewEncoder(fw).Encode(v)
+}
+
+// frame is a chunk of output of a stream.
+type frame struct {
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+// copyFrames writes what are read from r to w as frames of the stream. A
+// multi-byte character is never split into two frames.
+func copyFrames(w io.Writer, stream string, r io.Reader) error {
+	enc := json.NewEncoder(w)
+	buf := make([]byte, 32*1024)
+	var pending int
+	for {
+		n, err := r.Read(buf[pending:])
+		n += pending
+		cut := n
+		if err == nil {
+			cut = runeBoundary(buf[:n])
+		}
+		if cut > 0 {
+			if werr := enc.Encode(&frame{Stream: stream, Data: string(buf[:cut])}); werr != nil {
+				return werr
+			}
+		}
+		pending = copy(buf, buf[cut:n])
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// runeBoundary returns the length of p without an incomplete character at
+// the end.
+func runeBoundary(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return len(p) - i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+func localStreams(n int) ([]uint32, []net.Conn, error) {
+	ids := make([]uint32, n)
+	cs := make([]net.Conn, 0, n)
+	for i := range ids {
+		id, c, err := streamConn.local()
+		if err != nil {
+			closeAll(cs)
+			return nil, nil, err
+		}
+		ids[i] = id
+		cs = append(cs, c)
+	}
+	return ids, cs, nil
+}
+
+func closeAll(cs []net.Conn) {
+	for _, c := range cs {
+		c.Close()
+	}
+}
+
+// lockedWriter serializes writes from multiple streams and flushes each of
+// them immediately. header is called before the first write if set.
+type lockedWriter struct {
+	w       io.Writer
+	f       http.Flusher
+	header  func()
+	written bool
+	m       sync.Mutex
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if !w.written && w.header != nil {
+		w.header()
+	}
+	w.written = true
+	n, err := w.w.Write(p)
+	if w.f != nil {
+		w.f.Flush()
+	}
+	return n, err
+}
+
+func (w *lockedWriter) started() bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.written
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case errNotFound:
+		code = http.StatusNotFound
+	case errMethodNotAllowed:
+		code = http.StatusMethodNotAllowed
+	case errWebSocketRequired:
+		code = http.StatusBadRequest
+	case errUnauthorized:
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case errCrossOrigin:
+		code = http.StatusForbidden
+	case errNotJSON:
+		code = http.StatusUnsupportedMediaType
+	}
+	if _, ok := err.(*strconv.NumError); ok {
+		code = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func queryBool(r *http.Request, key string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(key))
+	return v
+}
+
+func queryUint(r *http.Request, key string, def uint) (uint, error) {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint(v), err
+}
@@ -0,0 +1,165 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type GatewaySuite struct{}
+
+var _ = Suite(&GatewaySuite{})
+
+func (s *GatewaySuite) TestSplitContainerPath(c *C) {
+	name, action := splitContainerPath("web")
+	c.Assert(name, Equals, "web")
+	c.Assert(action, Equals, "")
+	name, action = splitContainerPath("web/logs")
+	c.Assert(name, Equals, "web")
+	c.Assert(action, Equals, "logs")
+}
+
+func (s *GatewaySuite) TestError(c *C) {
+	g := NewGateway(nil, nil, "")
+	for _, t := range []struct {
+		method, path string
+		code         int
+	}{
+		{"GET", "/v1/unknown", http.StatusNotFound},
+		{"GET", "/v1/containers/web/unknown", http.StatusNotFound},
+		{"POST", "/v1/capability", http.StatusMethodNotAllowed},
+		{"GET", "/v1/containers/web/stop", http.StatusMethodNotAllowed},
+		{"POST", "/v1/containers/web/stop?timeout=x", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(t.method, t.path, nil))
+		c.Assert(w.Code, Equals, t.code, Commentf("%s %s", t.method, t.path))
+		c.Assert(w.Body.String(), Matches, `\{"error":".+"\}\n`)
+	}
+}
+
+func (s *GatewaySuite) TestAuthorize(c *C) {
+	for _, t := range []struct {
+		token, method, path string
+		header              map[string]string
+		code                int
+	}{
+		{"", "POST", "/v1/submit", nil, http.StatusUnsupportedMediaType},
+		{"", "POST", "/v1/submit", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"", "POST", "/v1/containers/web/exec", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"", "POST", "/v1/containers/web/start", map[string]string{"Origin": "http://evil.example.com"}, http.StatusForbidden},
+		{"", "POST", "/v1/submit", map[string]string{"Origin": "null", "Content-Type": "application/json"}, http.StatusForbidden},
+		{"", "GET", "/v1/containers/web/exec?cmd=sh", map[string]string{"Origin": "http://evil.example.com", "Connection": "Upgrade", "Upgrade": "websocket"}, http.StatusForbidden},
+		{"", "POST", "/v1/submit", map[string]string{"Origin": "http://example.com", "Content-Type": "application/json"}, http.StatusInternalServerError},
+		{"", "GET", "/v1/unknown", map[string]string{"Origin": "http://evil.example.com"}, http.StatusNotFound},
+		{"secret", "GET", "/v1/unknown", nil, http.StatusUnauthorized},
+		{"secret", "GET", "/v1/unknown", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+		{"secret", "GET", "/v1/unknown", map[string]string{"Authorization": "Bearer secret"}, http.StatusNotFound},
+	} {
+		g := NewGateway(nil, nil, t.token)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(t.method, t.path, strings.NewReader(`{}`))
+		for k, v := range t.header {
+			req.Header.Set(k, v)
+		}
+		g.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, t.code, Commentf("%s %s %v", t.method, t.path, t.header))
+	}
+}
+
+func (s *GatewaySuite) TestStream(c *C) {
+	g := NewGateway(nil, nil, "")
+	w := httptest.NewRecorder()
+	err := g.stream(w, httptest.NewRequest("GET", "/", nil), []string{"stdout", "stderr"}, func(ids []uint32) (interface{}, error) {
+		for i, id := range ids {
+			sc, err := streamConn.get(id)
+			c.Assert(err, IsNil)
+			fmt.Fprintf(sc, "stream %d\n", i)
+			sc.Close()
+		}
+		return &SubmitResponse{Agent: "agent1"}, nil
+	})
+	c.Assert(err, IsNil)
+	// streams are copied concurrently, so only the last line is ordered
+	lines := strings.Split(w.Body.String(), "\n")
+	c.Assert(lines, HasLen, 4)
+	sort.Strings(lines[:2])
+	c.Assert(lines[:2], DeepEquals, []string{
+		`{"stream":"stderr","data":"stream 1\n"}`,
+		`{"stream":"stdout","data":"stream 0\n"}`,
+	})
+	c.Assert(lines[2:], DeepEquals, []string{`{"Agent":"agent1"}`, ""})
+	c.Assert(w.Header().Get("Content-Type"), Equals, "application/x-ndjson")
+
+	w = httptest.NewRecorder()
+	err = g.stream(w, httptest.NewRequest("GET", "/", nil), []string{"stdout"}, func(ids []uint32) (interface{}, error) {
+		sc, err := streamConn.get(ids[0])
+		c.Assert(err, IsNil)
+		fmt.Fprintln(sc, "output")
+		sc.Close()
+		return nil, errors.New("failed")
+	})
+	c.Assert(err, IsNil)
+	c.Assert(w.Body.String(), Equals, `{"stream":"stdout","data":"output\n"}`+"\n"+`{"error":"failed"}`+"\n")
+}
+
+func (s *GatewaySuite) TestExecRequest(c *C) {
+	g := NewGateway(nil, nil, "")
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/containers/web/exec", strings.NewReader(`{"Cmd":["sh"],"Interactive":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	g.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusBadRequest)
+	c.Assert(w.Body.String(), Equals, `{"error":"WebSocket required"}`+"\n")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/containers/web/exec", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	g.ServeHTTP(w, req)
+	c.Assert(w.Body.String(), Equals, `{"error":"Cmd required"}`+"\n")
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("GET", "/v1/containers/web/exec?cmd=sh", nil))
+	c.Assert(w.Code, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *GatewaySuite) TestCopyFrames(c *C) {
+	// "é" is split across reads
+	r := io.MultiReader(strings.NewReader("caf\xc3"), strings.NewReader("\xa9 ok"))
+	var buf bytes.Buffer
+	c.Assert(copyFrames(&buf, "stdout", r), IsNil)
+	c.Assert(buf.String(), Equals, `{"stream":"stdout","data":"caf"}`+"\n"+`{"stream":"stdout","data":"é ok"}`+"\n")
+
+	c.Assert(runeBoundary([]byte("ab")), Equals, 2)
+	c.Assert(runeBoundary([]byte("a\xe3\x81")), Equals, 1)
+	c.Assert(runeBoundary([]byte("a\xe3\x81\x82")), Equals, 4)
+}
+
+func (s *GatewaySuite) TestStreamEarlyError(c *C) {
+	g := NewGateway(nil, nil, "")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	// streams are not taken, so they must be released without waiting
+	err := g.stream(w, httptest.NewRequest("GET", "/", nil), []string{"stdout", "stderr"}, func(ids []uint32) (interface{}, error) {
+		return nil, errors.New("failed")
+	})
+	c.Assert(err, ErrorMatches, "failed")
+	c.Assert(time.Since(start) < allocTimeout/2, Equals, true)
+	c.Assert(w.Body.Len(), Equals, 0)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/submit", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	g.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusInternalServerError)
+	c.Assert(w.Body.String(), Equals, `{"error":"Manifest required"}`+"\n")
+}
@@ -2,19 +2,21 @@ package rpc
 
 import (
 	"net"
+	"path"
 	"sort"
+
+	"github.com/yosisa/craft/config"
 )
 
-func ListIPAddrs() ([]string, error) {
+// ListIPAddrs returns addresses of the host selected by the config in order
+// of preference. The advertise address comes first if set.
+func ListIPAddrs(nc *config.NetworkConfig) ([]string, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 	var ips []net.IP
 	for _, iface := range ifaces {
-		if iface.Name == "docker0" {
-			continue
-		}
 		addrs, err := iface.Addrs()
 		if err != nil || len(addrs) == 0 {
 			continue
@@ -27,15 +29,72 @@ func ListIPAddrs() ([]string, error) {
 			if ip.IP.IsLoopback() || ip.IP.IsMulticast() {
 				continue
 			}
-			ips = append(ips, ip.IP)
+			// IPv6 link-local addresses are useless without the zone
+			if ip.IP.To4() == nil && ip.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			if selectAddr(nc, iface.Name, ip.IP) {
+				ips = append(ips, ip.IP)
+			}
+		}
+	}
+	return sortAddrs(ips, nc.Advertise), nil
+}
+
+// selectAddr reports whether the address of the interface is included and
+// not excluded.
+func selectAddr(nc *config.NetworkConfig, name string, ip net.IP) bool {
+	if len(nc.Include) > 0 && !matchAddr(nc.Include, name, ip) {
+		return false
+	}
+	return !matchAddr(nc.Exclude, name, ip)
+}
+
+func matchAddr(patterns []string, name string, ip net.IP) bool {
+	for _, p := range patterns {
+		if _, ipnet, err := net.ParseCIDR(p); err == nil {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		} else if ok, _ := path.Match(p, name); ok {
+			return true
 		}
 	}
-	sort.Sort(ByNetworkType(ips))
-	out := make([]string, len(ips))
-	for i, ip := range ips {
-		out[i] = ip.String()
+	return false
+}
+
+func sortAddrs(ips []net.IP, advertise string) []string {
+	sort.Stable(ByNetworkType(ips))
+	var out []string
+	if ip := net.ParseIP(advertise); ip != nil {
+		advertise = ip.String()
+	}
+	if advertise != "" {
+		out = append(out, advertise)
+	}
+	for _, ip := range ips {
+		if s := ip.String(); s != advertise {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// networkAddrs returns the addresses in each of the named networks.
+func networkAddrs(addrs []string, networks map[string]string) map[string][]string {
+	out := make(map[string][]string)
+	for name, cidr := range networks {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet.Contains(net.ParseIP(addr)) {
+				out[name] = append(out[name], addr)
+			}
+		}
 	}
-	return out, nil
+	return out
 }
 
 var networkOrder []*net.IPNet
@@ -70,6 +129,8 @@ func init() {
 		"10.0.0.0/8",
 		"169.254.0.0/16",
 		"0.0.0.0/0",
+		"fc00::/7",
+		"::/0",
 	} {
 		_, ipnet, err := net.ParseCIDR(s)
 		if err != nil {
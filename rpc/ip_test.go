@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"net"
+
+	"github.com/yosisa/craft/config"
+	. "gopkg.in/check.v1"
+)
+
+type IPSuite struct{}
+
+var _ = Suite(&IPSuite{})
+
+func (s *IPSuite) TestSelectAddr(c *C) {
+	nc := &config.NetworkConfig{Exclude: []string{"docker0", "veth*", "10.9.0.0/16"}}
+	for _, tc := range []struct {
+		iface    string
+		ip       string
+		selected bool
+	}{
+		{"eth0", "10.0.0.1", true},
+		{"docker0", "172.17.0.1", false},
+		{"veth1234", "172.17.0.2", false},
+		{"eth1", "10.9.0.1", false},
+	} {
+		c.Assert(selectAddr(nc, tc.iface, net.ParseIP(tc.ip)), Equals, tc.selected, Commentf("%s %s", tc.iface, tc.ip))
+	}
+
+	nc.Include = []string{"eth0", "fd00::/8"}
+	c.Assert(selectAddr(nc, "eth0", net.ParseIP("10.0.0.1")), Equals, true)
+	c.Assert(selectAddr(nc, "eth1", net.ParseIP("fd00::1")), Equals, true)
+	c.Assert(selectAddr(nc, "eth1", net.ParseIP("10.0.1.1")), Equals, false)
+	c.Assert(selectAddr(nc, "eth0", net.ParseIP("10.9.0.1")), Equals, false)
+}
+
+func (s *IPSuite) TestSortAddrs(c *C) {
+	var ips []net.IP
+	for _, s := range []string{"2001:db8::1", "203.0.113.1", "fd00::1", "10.0.0.1", "192.168.0.1"} {
+		ips = append(ips, net.ParseIP(s))
+	}
+	c.Assert(sortAddrs(ips, ""), DeepEquals, []string{
+		"192.168.0.1", "10.0.0.1", "203.0.113.1", "fd00::1", "2001:db8::1",
+	})
+	c.Assert(sortAddrs(ips, "203.0.113.1"), DeepEquals, []string{
+		"203.0.113.1", "192.168.0.1", "10.0.0.1", "fd00::1", "2001:db8::1",
+	})
+	c.Assert(sortAddrs(ips, "2001:0db8::0001")[0], Equals, "2001:db8::1")
+}
+
+func (s *IPSuite) TestNetworkAddrs(c *C) {
+	addrs := []string{"10.0.0.1", "10.1.0.1", "10.1.0.2", "fd00::1"}
+	networks := map[string]string{"mgmt": "10.0.0.0/16", "storage": "10.1.0.0/16", "v6": "fd00::/8", "public": "203.0.113.0/24"}
+	c.Assert(networkAddrs(addrs, networks), DeepEquals, map[string][]string{
+		"mgmt":    {"10.0.0.1"},
+		"storage": {"10.1.0.1", "10.1.0.2"},
+		"v6":      {"fd00::1"},
+	})
+}
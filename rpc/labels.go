@@ -0,0 +1,194 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/yosisa/craft/config"
+)
+
+const (
+	factsInterval = 5 * time.Minute
+	probeTimeout  = 30 * time.Second
+)
+
+// hostLabels adds labels computed on the agent to the static ones.
+var hostLabels *labeler
+
+// labeler computes labels from host facts and probe scripts. Static labels
+// in the config take precedence over probes, and probes over facts.
+type labeler struct {
+	docker  *docker.Client
+	devices []string
+	facts   map[string]string
+	probes  []map[string]string
+	stop    chan struct{}
+	m       sync.Mutex
+}
+
+func newLabeler(d *docker.Client) *labeler {
+	return &labeler{docker: d}
+}
+
+// merge returns a copy of static labels with the computed ones.
+func (l *labeler) merge(static map[string]string) map[string]string {
+	out := make(map[string]string)
+	if l != nil {
+		l.m.Lock()
+		for k, v := range l.facts {
+			out[k] = v
+		}
+		for _, labels := range l.probes {
+			for k, v := range labels {
+				out[k] = v
+			}
+		}
+		l.m.Unlock()
+	}
+	for k, v := range static {
+		out[k] = v
+	}
+	return out
+}
+
+// start stops running probes and starts gathering with the settings.
+func (l *labeler) start(devices []string, probes []config.Probe) {
+	l.m.Lock()
+	if l.stop != nil {
+		close(l.stop)
+	}
+	stop := make(chan struct{})
+	l.stop = stop
+	l.devices = devices
+	l.probes = make([]map[string]string, len(probes))
+	l.m.Unlock()
+
+	go l.run(stop, factsInterval, l.gatherFacts)
+	for i, p := range probes {
+		i, p := i, p
+		go l.run(stop, p.Duration(), func() {
+			labels, err := runProbe(p.Command)
+			if err != nil {
+				// keep the labels of the last run
+				log.WithFields(log.Fields{"error": err, "probe": p.Command}).Warning("Probe failed")
+				return
+			}
+			l.m.Lock()
+			defer l.m.Unlock()
+			if l.stop == stop {
+				l.probes[i] = labels
+			}
+		})
+	}
+}
+
+func (l *labeler) run(stop chan struct{}, interval time.Duration, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		f()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (l *labeler) gatherFacts() {
+	l.m.Lock()
+	devices := l.devices
+	l.m.Unlock()
+	facts := hostFacts(devices)
+	if l.docker != nil {
+		if env, err := l.docker.Version(); err == nil {
+			facts["docker_version"] = env.Get("Version")
+		}
+		if env, err := l.docker.Info(); err == nil {
+			facts["storage_driver"] = env.Get("Driver")
+		}
+	}
+	l.m.Lock()
+	l.facts = facts
+	l.m.Unlock()
+}
+
+// hostFacts returns labels describing the host.
+func hostFacts(devices []string) map[string]string {
+	facts := map[string]string{
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+		"cpus": strconv.Itoa(runtime.NumCPU()),
+	}
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		facts["kernel"] = strings.TrimSpace(string(b))
+	}
+	if mem, ok := memTotal("/proc/meminfo"); ok {
+		facts["memory"] = strconv.FormatInt(mem, 10)
+	}
+	for _, dev := range devices {
+		if _, err := os.Stat(dev); err == nil {
+			facts["device."+filepath.Base(dev)] = "true"
+		}
+	}
+	return facts
+}
+
+// memTotal returns the total memory in megabytes.
+func memTotal(path string) (int64, bool) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb / 1024, err == nil
+		}
+	}
+	return 0, false
+}
+
+// runProbe runs the command and parses lines of KEY=VALUE in its output as
+// labels.
+func runProbe(command string) (map[string]string, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	timer := time.AfterFunc(probeTimeout, func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	timer.Stop()
+	if err != nil {
+		return nil, err
+	}
+	return parseProbeOutput(&out), nil
+}
+
+func parseProbeOutput(out *bytes.Buffer) map[string]string {
+	labels := make(map[string]string)
+	s := bufio.NewScanner(out)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		n := strings.Index(line, "=")
+		if n <= 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels[strings.TrimSpace(line[:n])] = strings.TrimSpace(line[n+1:])
+	}
+	return labels
+}
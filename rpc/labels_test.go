@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/yosisa/craft/config"
+	. "gopkg.in/check.v1"
+)
+
+type LabelsSuite struct{}
+
+var _ = Suite(&LabelsSuite{})
+
+func (s *LabelsSuite) TestParseProbeOutput(c *C) {
+	out := bytes.NewBufferString("# comment\ngpu = tesla\nnoise\n=empty\nrack=r1=a\n")
+	c.Assert(parseProbeOutput(out), DeepEquals, map[string]string{"gpu": "tesla", "rack": "r1=a"})
+}
+
+func (s *LabelsSuite) TestMemTotal(c *C) {
+	path := filepath.Join(c.MkDir(), "meminfo")
+	ioutil.WriteFile(path, []byte("MemTotal:        8167848 kB\nMemFree:         1234 kB\n"), 0600)
+	mem, ok := memTotal(path)
+	c.Assert(ok, Equals, true)
+	c.Assert(mem, Equals, int64(7976))
+}
+
+func (s *LabelsSuite) TestHostFacts(c *C) {
+	dev := filepath.Join(c.MkDir(), "kvm")
+	ioutil.WriteFile(dev, nil, 0600)
+	facts := hostFacts([]string{dev, "/dev/nonexistent"})
+	c.Assert(facts["arch"], Equals, runtime.GOARCH)
+	c.Assert(facts["device.kvm"], Equals, "true")
+	_, ok := facts["device.nonexistent"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *LabelsSuite) TestMerge(c *C) {
+	l := newLabeler(nil)
+	l.start(nil, []config.Probe{
+		{Command: "echo zone=probe; echo rack=r1", Interval: "1h"},
+		{Command: "exit 1", Interval: "1h"},
+	})
+	defer close(l.stop)
+	var labels map[string]string
+	for i := 0; i < 100; i++ {
+		if labels = l.merge(map[string]string{"zone": "static"}); labels["rack"] != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(labels["rack"], Equals, "r1")
+	c.Assert(labels["zone"], Equals, "static")
+	c.Assert(labels["arch"], Equals, runtime.GOARCH)
+
+	var nilLabeler *labeler
+	c.Assert(nilLabeler.merge(map[string]string{"zone": "a"}), DeepEquals, map[string]string{"zone": "a"})
+}
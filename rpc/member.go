@@ -0,0 +1,278 @@
+package rpc
+
+import (
+	"encoding/gob"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/config"
+	"github.com/yosisa/craft/discovery"
+	"github.com/yosisa/craft/mux"
+)
+
+const (
+	gossipInterval = time.Second
+	suspectTimeout = 5 * time.Second
+	deadTimeout    = 30 * time.Second
+	reapTimeout    = 10 * time.Minute
+	// discoverTimeout is short not to delay gossip much.
+	discoverTimeout = 500 * time.Millisecond
+)
+
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberDead    = "dead"
+)
+
+// Member is an agent in the cluster. Heartbeat is increased only by the
+// agent itself, so a larger value means newer information. Incarnation is
+// the start time of the agent in nanoseconds, which tells a restarted agent
+// counting heartbeats from 0 again from a stale one.
+type Member struct {
+	Name        string
+	Addr        string
+	Incarnation int64
+	Heartbeat   uint64
+	State       string
+	LastSeen    time.Time
+}
+
+// newer reports whether m is newer information than cur.
+func (m *Member) newer(cur *Member) bool {
+	if m.Incarnation != cur.Incarnation {
+		return m.Incarnation > cur.Incarnation
+	}
+	return m.Heartbeat > cur.Heartbeat
+}
+
+type gossipMessage struct {
+	From    string
+	Members []Member
+}
+
+// memberList maintains cluster members by exchanging the list with a random
+// peer periodically.
+type memberList struct {
+	self    string
+	seeds   []string
+	members map[string]*Member
+	// discover returns addresses of agents found on the network. It's used
+	// while no other member is known.
+	discover func() []string
+	m        sync.Mutex
+}
+
+func newMemberList(name, addr string, seeds []string) *memberList {
+	return &memberList{
+		self:  name,
+		seeds: seeds,
+		members: map[string]*Member{
+			name: {Name: name, Addr: addr, Incarnation: time.Now().UnixNano(), LastSeen: time.Now()},
+		},
+	}
+}
+
+// gossipSeeds returns peers to join the cluster through, which are Seeds, or
+// Agents the agent is configured with if none.
+func gossipSeeds(c *config.Config) []string {
+	if len(c.Seeds) > 0 {
+		return c.Seeds
+	}
+	return c.Agents
+}
+
+// discoverPeers returns a function to discover agents other than self.
+func discoverPeers(addr string) func() []string {
+	return func() []string {
+		found, err := discovery.Discover(addr, discoverTimeout)
+		if err != nil {
+			log.WithField("error", err).Debug("Failed to discover peers")
+		}
+		self, _ := currentAgent()
+		var out []string
+		for _, a := range found {
+			if a.Name != self {
+				out = append(out, a.Addr)
+			}
+		}
+		return out
+	}
+}
+
+func (l *memberList) run() {
+	for range time.Tick(gossipInterval) {
+		addr := l.next()
+		if addr == "" {
+			continue
+		}
+		c, err := mux.DialTimeout("tcp", addr, chanGossip, dialTimeout)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "peer": addr}).Debug("Failed to connect peer")
+			continue
+		}
+		if err = l.exchange(c); err != nil {
+			log.WithFields(log.Fields{"error": err, "peer": addr}).Debug("Failed to gossip")
+		}
+		c.Close()
+	}
+}
+
+// update applies the reloaded name and seeds. Peers forget the old name as
+// it's never updated.
+func (l *memberList) update(name string, seeds []string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.seeds = seeds
+	if name == l.self {
+		return
+	}
+	self := l.members[l.self]
+	delete(l.members, l.self)
+	self.Name = name
+	l.members[name] = self
+	l.self = name
+}
+
+// beat increases the heartbeat of itself, drops members dead for a long
+// time and returns an address of the next peer to gossip with.
+func (l *memberList) beat() string {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	self := l.members[l.self]
+	self.Heartbeat++
+	self.LastSeen = now
+
+	peers := append([]string(nil), l.seeds...)
+	for name, m := range l.members {
+		switch {
+		case name == l.self:
+		case now.Sub(m.LastSeen) > reapTimeout:
+			delete(l.members, name)
+		case now.Sub(m.LastSeen) <= deadTimeout:
+			peers = append(peers, m.Addr)
+		}
+	}
+	if len(peers) == 0 {
+		return ""
+	}
+	return peers[rand.Intn(len(peers))]
+}
+
+// next is like beat, but tries agents discovered on the network while no
+// other member is known.
+func (l *memberList) next() string {
+	addr := l.beat()
+	l.m.Lock()
+	alone := len(l.members) == 1
+	l.m.Unlock()
+	if alone && l.discover != nil {
+		if found := l.discover(); len(found) > 0 {
+			return found[rand.Intn(len(found))]
+		}
+	}
+	return addr
+}
+
+// exchange sends the list to the peer and merges the list of the peer.
+func (l *memberList) exchange(c net.Conn) error {
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err := gob.NewEncoder(c).Encode(l.message()); err != nil {
+		return err
+	}
+	var msg gossipMessage
+	if err := gob.NewDecoder(c).Decode(&msg); err != nil {
+		return err
+	}
+	l.merge(&msg, c.RemoteAddr())
+	return nil
+}
+
+// handle is the other side of exchange.
+func (l *memberList) handle(c net.Conn) error {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	var msg gossipMessage
+	if err := gob.NewDecoder(c).Decode(&msg); err != nil {
+		return err
+	}
+	l.merge(&msg, c.RemoteAddr())
+	return gob.NewEncoder(c).Encode(l.message())
+}
+
+func (l *memberList) message() *gossipMessage {
+	l.m.Lock()
+	defer l.m.Unlock()
+	msg := &gossipMessage{From: l.self}
+	for _, m := range l.members {
+		// don't spread dead members, otherwise they are never reaped
+		if time.Since(m.LastSeen) <= deadTimeout {
+			msg.Members = append(msg.Members, *m)
+		}
+	}
+	return msg
+}
+
+func (l *memberList) merge(msg *gossipMessage, remote net.Addr) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	for _, m := range msg.Members {
+		if m.Name == l.self {
+			continue
+		}
+		if m.Name == msg.From {
+			// the sender may not know its own host
+			if addr, ok := remote.(*net.TCPAddr); ok {
+				if filled, err := discovery.FillHost(m.Addr, addr.IP); err == nil {
+					m.Addr = filled
+				}
+			}
+		}
+		if cur, ok := l.members[m.Name]; ok && !m.newer(cur) {
+			continue
+		}
+		l.members[m.Name] = &Member{Name: m.Name, Addr: m.Addr, Incarnation: m.Incarnation, Heartbeat: m.Heartbeat, LastSeen: now}
+	}
+}
+
+// list returns members sorted by name with their states.
+func (l *memberList) list() []Member {
+	l.m.Lock()
+	defer l.m.Unlock()
+	now := time.Now()
+	var out []Member
+	for _, m := range l.members {
+		v := *m
+		switch d := now.Sub(v.LastSeen); {
+		case d > deadTimeout:
+			v.State = MemberDead
+		case d > suspectTimeout:
+			v.State = MemberSuspect
+		default:
+			v.State = MemberAlive
+		}
+		out = append(out, v)
+	}
+	sort.Sort(byMemberName(out))
+	return out
+}
+
+type byMemberName []Member
+
+func (s byMemberName) Len() int {
+	return len(s)
+}
+
+func (s byMemberName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byMemberName) Less(i, j int) bool {
+	return s[i].Name < s[j].Name
+}
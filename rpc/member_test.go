@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yosisa/craft/config"
+)
+
+func gossip(t *testing.T, a, b *memberList) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errc := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- b.handle(c)
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err = a.exchange(c); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemberListGossip(t *testing.T) {
+	a := newMemberList("a", ":7300", nil)
+	b := newMemberList("b", "10.0.0.2:7300", nil)
+	c := newMemberList("c", "10.0.0.3:7300", nil)
+	a.beat()
+	gossip(t, a, b)
+	gossip(t, c, b)
+
+	members := c.list()
+	if len(members) != 3 {
+		t.Fatalf("expected 3 members, but %v", members)
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		if m := members[i]; m.Name != name || m.State != MemberAlive {
+			t.Fatalf("unexpected member: %+v", m)
+		}
+	}
+	// the host of a is filled by b
+	if addr := members[0].Addr; addr != "127.0.0.1:7300" {
+		t.Fatalf("unexpected address of a: %s", addr)
+	}
+	if hb := members[0].Heartbeat; hb != 1 {
+		t.Fatalf("unexpected heartbeat of a: %d", hb)
+	}
+
+	// stale information must not overwrite newer one
+	c.members["a"].Heartbeat = 5
+	gossip(t, c, b)
+	if hb := c.members["a"].Heartbeat; hb != 5 {
+		t.Fatalf("heartbeat went back to %d", hb)
+	}
+	if hb := b.members["a"].Heartbeat; hb != 5 {
+		t.Fatalf("heartbeat not propagated: %d", hb)
+	}
+}
+
+func TestMemberListState(t *testing.T) {
+	l := newMemberList("a", ":7300", nil)
+	now := time.Now()
+	l.members["b"] = &Member{Name: "b", LastSeen: now.Add(-suspectTimeout - time.Second)}
+	l.members["c"] = &Member{Name: "c", LastSeen: now.Add(-deadTimeout - time.Second)}
+	l.members["d"] = &Member{Name: "d", LastSeen: now.Add(-reapTimeout - time.Second)}
+
+	states := make(map[string]string)
+	for _, m := range l.list() {
+		states[m.Name] = m.State
+	}
+	expected := map[string]string{"a": MemberAlive, "b": MemberSuspect, "c": MemberDead, "d": MemberDead}
+	for name, state := range expected {
+		if states[name] != state {
+			t.Fatalf("%s expected %s, but %s", name, state, states[name])
+		}
+	}
+
+	for _, m := range l.message().Members {
+		if m.Name == "c" || m.Name == "d" {
+			t.Fatalf("dead member %s must not be spread", m.Name)
+		}
+	}
+	if peer := l.beat(); peer != "" && peer != l.members["b"].Addr {
+		t.Fatalf("unexpected peer: %s", peer)
+	}
+	if _, ok := l.members["d"]; ok {
+		t.Fatal("d must be reaped")
+	}
+}
+
+func TestMemberListUpdate(t *testing.T) {
+	l := newMemberList("a", ":7300", nil)
+	l.beat()
+	l.update("b", []string{"10.0.0.2:7300"})
+	members := l.list()
+	if len(members) != 1 || members[0].Name != "b" || members[0].Heartbeat != 1 {
+		t.Fatalf("unexpected members: %v", members)
+	}
+	if addr := l.beat(); addr != "10.0.0.2:7300" {
+		t.Fatalf("seed must be used: %s", addr)
+	}
+}
+
+func TestMemberListDiscover(t *testing.T) {
+	l := newMemberList("a", ":7300", []string{"10.0.0.9:7300"})
+	l.discover = func() []string { return []string{"10.0.0.2:7300"} }
+	if addr := l.next(); addr != "10.0.0.2:7300" {
+		t.Fatalf("discovered agent must be used while alone: %s", addr)
+	}
+
+	l.merge(&gossipMessage{From: "b", Members: []Member{{Name: "b", Addr: "10.0.0.3:7300", Heartbeat: 1}}}, nil)
+	l.discover = func() []string {
+		t.Fatal("discovery must not be used once members are known")
+		return nil
+	}
+	if addr := l.next(); addr != "10.0.0.3:7300" && addr != "10.0.0.9:7300" {
+		t.Fatalf("unexpected peer: %s", addr)
+	}
+}
+
+func TestGossipSeeds(t *testing.T) {
+	c := &config.Config{Agents: []string{"10.0.0.1:7300"}}
+	if seeds := gossipSeeds(c); len(seeds) != 1 || seeds[0] != "10.0.0.1:7300" {
+		t.Fatalf("agents must be used without seeds: %v", seeds)
+	}
+	c.Seeds = []string{"10.0.0.2:7300"}
+	if seeds := gossipSeeds(c); len(seeds) != 1 || seeds[0] != "10.0.0.2:7300" {
+		t.Fatalf("seeds must be preferred: %v", seeds)
+	}
+}
+
+func TestMemberListRestart(t *testing.T) {
+	a := newMemberList("a", ":7300", nil)
+	b := newMemberList("b", "10.0.0.2:7300", nil)
+	for i := 0; i < 5; i++ {
+		a.beat()
+	}
+	gossip(t, a, b)
+	if hb := b.members["a"].Heartbeat; hb != 5 {
+		t.Fatalf("unexpected heartbeat of a: %d", hb)
+	}
+
+	// a restarts on another port and counts heartbeats from 0 again
+	a = newMemberList("a", ":7400", nil)
+	// started later even if the clock is coarse
+	a.members["a"].Incarnation = b.members["a"].Incarnation + 1
+	a.beat()
+	gossip(t, a, b)
+	m := b.members["a"]
+	if m.Heartbeat != 1 || m.Addr != "127.0.0.1:7400" {
+		t.Fatalf("restarted member not updated: %+v", m)
+	}
+
+	// the old incarnation is stale even with a larger heartbeat
+	stale := &gossipMessage{From: "c", Members: []Member{{Name: "a", Addr: "127.0.0.1:7300", Incarnation: m.Incarnation - 1, Heartbeat: 10}}}
+	b.merge(stale, nil)
+	if addr := b.members["a"].Addr; addr != "127.0.0.1:7400" {
+		t.Fatalf("stale incarnation applied: %s", addr)
+	}
+}
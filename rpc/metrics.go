@@ -0,0 +1,282 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yosisa/craft/docker"
+)
+
+const namespace = "craft"
+
+var (
+	rpcCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_calls_total",
+		Help:      "Number of RPC calls by method and outcome.",
+	}, []string{"method", "outcome"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of RPC calls by method.",
+	}, []string{"method"})
+	submitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "submit_duration_seconds",
+		Help:      "Duration of submits by outcome, excluding lock wait.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"outcome"})
+	imageBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_bytes_total",
+		Help:      "Bytes of images pulled or loaded.",
+	}, []string{"op"})
+	imageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_duration_seconds",
+		Help:      "Duration of image pulls or loads by outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"op", "outcome"})
+	activeStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_streams",
+		Help:      "Number of stream connections in use.",
+	})
+	lockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lock_wait_seconds",
+		Help:      "Time waited for names and ports reserved by other submits.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(rpcCalls, rpcDuration, submitDuration, imageBytes, imageDuration, activeStreams, lockWait)
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// observeImage records the duration of an image operation started at start.
+func observeImage(op string, start time.Time, err error) {
+	imageDuration.WithLabelValues(op, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// usageCollector reports running containers and used ports when scraped.
+type usageCollector struct {
+	c          *docker.Client
+	containers *prometheus.Desc
+	ports      *prometheus.Desc
+}
+
+func newUsageCollector(c *docker.Client) *usageCollector {
+	return &usageCollector{
+		c: c,
+		containers: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "running_containers"),
+			"Number of running containers.", nil, nil),
+		ports: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "used_ports"),
+			"Number of host ports used by running containers.", nil, nil),
+	}
+}
+
+func (u *usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- u.containers
+	ch <- u.ports
+}
+
+func (u *usageCollector) Collect(ch chan<- prometheus.Metric) {
+	ui, err := u.c.Usage()
+	if err != nil {
+		log.WithField("error", err).Error("Failed to get usage")
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(u.containers, prometheus.GaugeValue, float64(len(ui.UsedNames)))
+	ch <- prometheus.MustNewConstMetric(u.ports, prometheus.GaugeValue, float64(len(ui.UsedPorts)))
+}
+
+// serverCodec is the gob codec of net/rpc which also records calls in the
+// metrics and the audit log.
+type serverCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+	calls  map[uint64]*call
+	seq    uint64
+	m      sync.Mutex
+}
+
+type call struct {
+	start time.Time
+	args  interface{}
+}
+
+func newServerCodec(conn io.ReadWriteCloser) *serverCodec {
+	buf := bufio.NewWriter(conn)
+	return &serverCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+		calls:  make(map[uint64]*call),
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	if err := c.dec.Decode(r); err != nil {
+		return err
+	}
+	c.m.Lock()
+	c.calls[r.Seq] = &call{start: time.Now()}
+	c.seq = r.Seq
+	c.m.Unlock()
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	// the body always follows its header
+	c.m.Lock()
+	if cl, ok := c.calls[c.seq]; ok {
+		cl.args = body
+	}
+	c.m.Unlock()
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	c.m.Lock()
+	cl, ok := c.calls[r.Seq]
+	delete(c.calls, r.Seq)
+	c.m.Unlock()
+	if ok {
+		var callErr error
+		if r.Error != "" {
+			callErr = errors.New(r.Error)
+		}
+		rpcCalls.WithLabelValues(r.ServiceMethod, outcome(callErr)).Inc()
+		rpcDuration.WithLabelValues(r.ServiceMethod).Observe(time.Since(cl.start).Seconds())
+		audit(remoteAddr(c.rwc), identity(c.rwc), r.ServiceMethod, cl.args, cl.start, callErr)
+	}
+
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob couldn't encode the header, shut down
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob couldn't encode the body, shut down
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// countingReader counts bytes read into the counter.
+type countingReader struct {
+	r io.Reader
+	c prometheus.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.c.Add(float64(n))
+	return n, err
+}
+
+const maxProgressLine = 64 * 1024
+
+// pullCounter passes the JSON progress of image pulls through and counts
+// the size of layers downloaded.
+type pullCounter struct {
+	w      io.Writer
+	buf    []byte
+	totals map[string]int64
+}
+
+func newPullCounter(w io.Writer) *pullCounter {
+	return &pullCounter{w: w, totals: make(map[string]int64)}
+}
+
+type pullProgress struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	ProgressDetail struct {
+		Total int64 `json:"total"`
+	} `json:"progressDetail"`
+}
+
+func (p *pullCounter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		n := bytes.IndexByte(p.buf, '\n')
+		if n < 0 {
+			break
+		}
+		var msg pullProgress
+		if json.Unmarshal(p.buf[:n], &msg) == nil {
+			switch msg.Status {
+			case "Downloading":
+				p.totals[msg.ID] = msg.ProgressDetail.Total
+			case "Download complete":
+				imageBytes.WithLabelValues("pull").Add(float64(p.totals[msg.ID]))
+				delete(p.totals, msg.ID)
+			}
+		}
+		p.buf = p.buf[n+1:]
+	}
+	if len(p.buf) > maxProgressLine {
+		p.buf = nil
+	}
+	return p.w.Write(b)
+}
+
+// trackedConn decreases active streams when closed. It's also closed on
+// shutdown.
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func newTrackedConn(c net.Conn) *trackedConn {
+	activeStreams.Inc()
+	tc := &trackedConn{Conn: c}
+	openStreams.add(tc)
+	return tc
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		activeStreams.Dec()
+		openStreams.remove(c)
+	})
+	return c.Conn.Close()
+}
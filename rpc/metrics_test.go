@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+
+	. "gopkg.in/check.v1"
+)
+
+type MetricsSuite struct{}
+
+var _ = Suite(&MetricsSuite{})
+
+func (s *MetricsSuite) TestServerCodec(c *C) {
+	sc, cc := net.Pipe()
+	codec := newServerCodec(sc)
+	go rpc.ServeCodec(codec)
+	client := rpc.NewClient(cc)
+	defer client.Close()
+
+	var resp AllocResponse
+	c.Assert(client.Call("StreamConn.Alloc", Empty{}, &resp), IsNil)
+	defer streamConn.release(resp.ID)
+	err := client.Call("Echo.Echo", EchoRequest{StreamID: resp.ID + 1}, &Empty{})
+	c.Assert(err, ErrorMatches, "Invalid stream id: .*")
+	codec.m.Lock()
+	defer codec.m.Unlock()
+	c.Assert(codec.calls, HasLen, 0)
+}
+
+func (s *MetricsSuite) TestPullCounter(c *C) {
+	var buf bytes.Buffer
+	p := newPullCounter(&buf)
+	lines := []string{
+		`{"status":"Pulling fs layer","id":"a"}` + "\n",
+		`{"status":"Downloading","progressDetail":{"current":10,"total":100},"id":"a"}` + "\n",
+		`{"status":"Downloading","progressDetail":{"current":50,"total":100},`,
+		`"id":"a"}` + "\n" + `{"status":"Downloading","progressDetail":{"current":1,"total":20},"id":"b"}` + "\n",
+		`{"status":"Download complete","id":"a"}` + "\n",
+	}
+	for _, l := range lines {
+		n, err := p.Write([]byte(l))
+		c.Assert(err, IsNil)
+		c.Assert(n, Equals, len(l))
+	}
+	c.Assert(buf.String(), Equals, lines[0]+lines[1]+lines[2]+lines[3]+lines[4])
+	c.Assert(p.totals, DeepEquals, map[string]int64{"b": 20})
+	c.Assert(p.buf, HasLen, 0)
+}
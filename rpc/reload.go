@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/config"
+	"github.com/yosisa/craft/secret"
+)
+
+const configCheckInterval = 5 * time.Second
+
+// currentAgent returns the name and labels of the agent, which may be
+// changed by reloading.
+func currentAgent() (string, map[string]string) {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return agentName, hostLabels.merge(labels)
+}
+
+// currentAddrs returns addresses of the agent reported to clients.
+func currentAddrs() ([]string, map[string][]string) {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return ipAddrs, networks
+}
+
+func currentAuditor() *auditLog {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return auditor
+}
+
+func currentSecrets() *secret.Store {
+	stateMu.RLock()
+	defer stateMu.RUnlock()
+	return secrets
+}
+
+// applyConfig applies settings which can be changed without restart. old is
+// nil on startup. Nothing is applied if an error is returned.
+func applyConfig(old, c *config.Config) error {
+	// interfaces may have changed even if the config hasn't
+	ips, err := ListIPAddrs(&c.Network)
+	if err != nil {
+		return err
+	}
+	al := currentAuditor()
+	if old == nil || old.Audit != c.Audit {
+		if err = os.MkdirAll(filepath.Dir(c.Audit.Path), 0755); err == nil {
+			al, err = openAuditLog(c.Audit.Path, c.Audit.MaxSize*1024*1024, c.Audit.MaxFiles)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"error": err, "path": c.Audit.Path}).Error("Failed to open audit log, auditing is disabled")
+			al = nil
+		}
+	}
+	var store *secret.Store
+	if key, err := secret.LoadKey(c.SecretKey); err == nil {
+		if store, err = secret.OpenStore(c.Secrets, key); err != nil {
+			if al != nil && al != currentAuditor() {
+				al.close()
+			}
+			return err
+		}
+	} else {
+		log.WithFields(log.Fields{"error": err, "path": c.SecretKey}).Warning("Failed to load secret key, secrets are disabled")
+	}
+
+	stateMu.Lock()
+	prev := auditor
+	agentName = c.AgentName
+	labels = c.Labels
+	ipAddrs = ips
+	networks = networkAddrs(ips, c.Network.Networks)
+	auditor = al
+	secrets = store
+	stateMu.Unlock()
+	if prev != nil && prev != al {
+		prev.close()
+	}
+	if members != nil {
+		members.update(c.AgentName, gossipSeeds(c))
+	}
+	if hostLabels != nil && (old == nil || !reflect.DeepEqual(old.Devices, c.Devices) || !reflect.DeepEqual(old.Probes, c.Probes)) {
+		hostLabels.start(c.Devices, c.Probes)
+	}
+	return nil
+}
+
+// restartRequired returns settings changed but not applied until restart.
+func restartRequired(old, c *config.Config) []string {
+	var out []string
+	for _, s := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"listen", old.Listen, c.Listen},
+		{"docker", old.Docker, c.Docker},
+		{"http", old.HTTP, c.HTTP},
+		{"http_token", old.HTTPToken, c.HTTPToken},
+		{"dns", old.DNS, c.DNS},
+		{"discovery", old.Discovery, c.Discovery},
+		{"announce", old.Announce, c.Announce},
+		{"shutdown_timeout", old.ShutdownTimeout, c.ShutdownTimeout},
+	} {
+		if !reflect.DeepEqual(s.old, s.new) {
+			out = append(out, s.name)
+		}
+	}
+	return out
+}
+
+// reload parses the config file again and applies it. The returned config
+// keeps the running values of settings which require restart.
+func reload(cur *config.Config) *config.Config {
+	c, err := config.Parse(cur.Path)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "path": cur.Path}).Error("Failed to reload config")
+		return cur
+	}
+	if err = applyConfig(cur, c); err != nil {
+		log.WithFields(log.Fields{"error": err, "path": cur.Path}).Error("Failed to reload config")
+		return cur
+	}
+	for _, name := range restartRequired(cur, c) {
+		log.WithField("setting", name).Warning("Setting changed, restart the agent to apply it")
+	}
+	c.Listen, c.Docker, c.HTTP, c.DNS = cur.Listen, cur.Docker, cur.HTTP, cur.DNS
+	c.HTTPToken = cur.HTTPToken
+	c.Discovery, c.Announce = cur.Discovery, cur.Announce
+	log.WithField("agent", c.AgentName).Info("Config reloaded")
+	return c
+}
+
+// watchConfig reloads the config on SIGHUP or when the file is modified.
+func watchConfig(c *config.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if c.Path != "" {
+		tick = time.Tick(configCheckInterval)
+	}
+	mtime := modTime(c.Path)
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			if m := modTime(c.Path); m.Equal(mtime) {
+				continue
+			}
+		}
+		mtime = modTime(c.Path)
+		c = reload(c)
+	}
+}
+
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/yosisa/craft/config"
+	. "gopkg.in/check.v1"
+)
+
+type ReloadSuite struct {
+	dir string
+}
+
+var _ = Suite(&ReloadSuite{})
+
+func (s *ReloadSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *ReloadSuite) TearDownTest(c *C) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if auditor != nil {
+		auditor.close()
+	}
+	agentName, labels, auditor, secrets = "", nil, nil, nil
+}
+
+func (s *ReloadSuite) writeConfig(c *C, body string) string {
+	path := filepath.Join(s.dir, "craft.json")
+	audit := filepath.Join(s.dir, "audit.log")
+	conf := fmt.Sprintf(`{"audit": {"path": %q}, "secret_key": %q, %s}`, audit, filepath.Join(s.dir, "key"), body)
+	c.Assert(ioutil.WriteFile(path, []byte(conf), 0600), IsNil)
+	return path
+}
+
+func (s *ReloadSuite) TestReload(c *C) {
+	path := s.writeConfig(c, `"agent_name": "agent1", "labels": {"zone": "a"}`)
+	conf, err := config.Parse(path)
+	c.Assert(err, IsNil)
+	c.Assert(applyConfig(nil, conf), IsNil)
+	name, ls := currentAgent()
+	c.Assert(name, Equals, "agent1")
+	c.Assert(ls, DeepEquals, map[string]string{"zone": "a"})
+	al := currentAuditor()
+	c.Assert(al, NotNil)
+
+	s.writeConfig(c, `"agent_name": "agent2", "labels": {"zone": "b"}, "listen": ":7400"`)
+	conf = reload(conf)
+	name, ls = currentAgent()
+	c.Assert(name, Equals, "agent2")
+	c.Assert(ls, DeepEquals, map[string]string{"zone": "b"})
+	c.Assert(conf.Listen, Equals, ":7300")
+	// unchanged audit settings keep the log open
+	c.Assert(currentAuditor(), Equals, al)
+
+	// a broken file keeps the current settings
+	c.Assert(ioutil.WriteFile(path, []byte("{"), 0600), IsNil)
+	c.Assert(reload(conf), Equals, conf)
+	name, _ = currentAgent()
+	c.Assert(name, Equals, "agent2")
+}
+
+func (s *ReloadSuite) TestRestartRequired(c *C) {
+	old := &config.Config{Listen: ":7300", HTTP: ":8080", Labels: map[string]string{"zone": "a"}}
+	conf := &config.Config{Listen: ":7400", HTTP: ":8080", Announce: true, Labels: map[string]string{"zone": "b"}}
+	c.Assert(restartRequired(old, conf), DeepEquals, []string{"listen", "announce"})
+}
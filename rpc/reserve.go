@@ -0,0 +1,222 @@
+package rpc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yosisa/craft/docker"
+)
+
+// reserveTimeout is how long a submit waits for another one holding the same
+// names or ports.
+const reserveTimeout = 10 * time.Minute
+
+// reservations holds container names and host ports claimed by submits in
+// progress. Submits sharing none of them run in parallel.
+type reservations struct {
+	names   map[string]struct{}
+	ports   map[int64]struct{}
+	gen     uint64 // increased on release
+	timeout time.Duration
+	closed  bool
+	m       sync.Mutex
+	cond    *sync.Cond
+}
+
+func newReservations() *reservations {
+	r := &reservations{
+		names:   make(map[string]struct{}),
+		ports:   make(map[int64]struct{}),
+		timeout: reserveTimeout,
+	}
+	r.cond = sync.NewCond(&r.m)
+	return r
+}
+
+// reservation is the set of resources held by a submit.
+type reservation struct {
+	r     *reservations
+	names []string
+	ports []int64
+}
+
+// acquire claims all the resources at once, waiting while any of them is
+// held by another submit. It fails on the timeout or when closed.
+func (r *reservations) acquire(names []string, ports []int64) (*reservation, error) {
+	start := time.Now()
+	defer func() {
+		lockWait.Observe(time.Since(start).Seconds())
+	}()
+	deadline := start.Add(r.timeout)
+	// wake up to see the deadline
+	timer := time.AfterFunc(r.timeout, func() {
+		r.m.Lock()
+		r.cond.Broadcast()
+		r.m.Unlock()
+	})
+	defer timer.Stop()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	for {
+		if r.closed {
+			return nil, errShuttingDown
+		}
+		if r.free(names, ports) {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("Timeout: waiting for another submit using the names or ports: %v %v", names, ports)
+		}
+		r.cond.Wait()
+	}
+	for _, name := range names {
+		r.names[name] = struct{}{}
+	}
+	for _, port := range ports {
+		r.ports[port] = struct{}{}
+	}
+	return &reservation{r: r, names: names, ports: ports}, nil
+}
+
+// close makes waiting and later acquires fail, e.g. on shutdown.
+func (r *reservations) close() {
+	r.m.Lock()
+	r.closed = true
+	r.m.Unlock()
+	r.cond.Broadcast()
+}
+
+// allocate picks and reserves a free host port from each of the ranges.
+// used returns ports in use by containers. It's called again when a submit
+// finishes meanwhile, since its container may be missing from the result.
+func (rv *reservation) allocate(ranges [][2]int64, used func() ([]int64, error)) ([]int64, error) {
+	r := rv.r
+	for {
+		r.m.Lock()
+		gen := r.gen
+		r.m.Unlock()
+		inUse, err := used()
+		if err != nil {
+			return nil, err
+		}
+
+		r.m.Lock()
+		if r.gen != gen {
+			r.m.Unlock()
+			continue
+		}
+		taken := make(map[int64]bool)
+		for _, port := range inUse {
+			taken[port] = true
+		}
+		var out []int64
+		for _, rg := range ranges {
+			port, ok := r.pick(rg, taken)
+			if !ok {
+				for _, port := range out {
+					delete(r.ports, port)
+				}
+				r.m.Unlock()
+				return nil, fmt.Errorf("No free host port in %d-%d", rg[0], rg[1])
+			}
+			r.ports[port] = struct{}{}
+			out = append(out, port)
+		}
+		rv.ports = append(rv.ports, out...)
+		r.m.Unlock()
+		return out, nil
+	}
+}
+
+// release frees all the resources of the reservation.
+func (rv *reservation) release() {
+	r := rv.r
+	r.m.Lock()
+	for _, name := range rv.names {
+		delete(r.names, name)
+	}
+	for _, port := range rv.ports {
+		delete(r.ports, port)
+	}
+	r.gen++
+	r.m.Unlock()
+	r.cond.Broadcast()
+}
+
+func (r *reservations) pick(rg [2]int64, taken map[int64]bool) (int64, bool) {
+	for port := rg[0]; port <= rg[1]; port++ {
+		if _, ok := r.ports[port]; !ok && !taken[port] {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+func (r *reservations) free(names []string, ports []int64) bool {
+	for _, name := range names {
+		if _, ok := r.names[name]; ok {
+			return false
+		}
+	}
+	for _, port := range ports {
+		if _, ok := r.ports[port]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the reserved names and ports in order.
+func (r *reservations) list() ([]string, []int64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var names []string
+	for name := range r.names {
+		names = append(names, name)
+	}
+	var ports []int64
+	for port := range r.ports {
+		ports = append(ports, port)
+	}
+	sort.Strings(names)
+	sort.Sort(byPort(ports))
+	return names, ports
+}
+
+type byPort []int64
+
+func (s byPort) Len() int           { return len(s) }
+func (s byPort) Less(i, j int) bool { return s[i] < s[j] }
+func (s byPort) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// manifestResources returns the names and fixed host ports a submit of the
+// manifest touches, including the container to be replaced, and the ranges
+// of host ports to be allocated.
+func manifestResources(m *docker.Manifest) ([]string, []int64, [][2]int64) {
+	names := []string{m.Name}
+	if m.Replace != "" && m.Replace != m.Name {
+		names = append(names, m.Replace)
+	}
+	var ports []int64
+	var ranges [][2]int64
+	for _, p := range m.Ports {
+		if p.Dynamic() {
+			ranges = append(ranges, [2]int64{p.HostPortMin, p.HostPortMax})
+		} else if p.HostPort > 0 {
+			ports = append(ports, p.HostPort)
+		}
+	}
+	return names, ports, ranges
+}
+
+func containsPort(ports []int64, port int64) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
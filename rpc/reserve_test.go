@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/yosisa/craft/docker"
+	. "gopkg.in/check.v1"
+)
+
+type ReserveSuite struct{}
+
+var _ = Suite(&ReserveSuite{})
+
+func mustAcquire(c *C, r *reservations, names []string, ports []int64) *reservation {
+	rv, err := r.acquire(names, ports)
+	c.Assert(err, IsNil)
+	return rv
+}
+
+func (s *ReserveSuite) TestIndependent(c *C) {
+	r := newReservations()
+	rv := mustAcquire(c, r, []string{"web"}, []int64{80})
+	done := make(chan struct{})
+	go func() {
+		if rv, err := r.acquire([]string{"db"}, []int64{5432}); err == nil {
+			rv.release()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		c.Fatal("Independent submit blocked")
+	}
+
+	names, ports := r.list()
+	c.Assert(names, DeepEquals, []string{"web"})
+	c.Assert(ports, DeepEquals, []int64{80})
+	rv.release()
+	names, ports = r.list()
+	c.Assert(names, HasLen, 0)
+	c.Assert(ports, HasLen, 0)
+}
+
+func (s *ReserveSuite) TestConflict(c *C) {
+	r := newReservations()
+	for _, tc := range []struct {
+		names []string
+		ports []int64
+	}{
+		{[]string{"web"}, nil},
+		{[]string{"api"}, []int64{80}},
+	} {
+		rv := mustAcquire(c, r, []string{"web"}, []int64{80})
+		acquired := make(chan struct{})
+		go func(names []string, ports []int64) {
+			if rv, err := r.acquire(names, ports); err == nil {
+				rv.release()
+			}
+			close(acquired)
+		}(tc.names, tc.ports)
+		select {
+		case <-acquired:
+			c.Fatalf("Acquired while held: %v %v", tc.names, tc.ports)
+		case <-time.After(50 * time.Millisecond):
+		}
+		rv.release()
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			c.Fatalf("Not acquired after release: %v %v", tc.names, tc.ports)
+		}
+	}
+}
+
+func (s *ReserveSuite) TestTimeout(c *C) {
+	r := newReservations()
+	r.timeout = 50 * time.Millisecond
+	rv := mustAcquire(c, r, []string{"web"}, nil)
+	defer rv.release()
+	_, err := r.acquire([]string{"web"}, nil)
+	c.Assert(err, ErrorMatches, "Timeout: .*")
+
+	// others are not affected
+	mustAcquire(c, r, []string{"api"}, nil).release()
+}
+
+func (s *ReserveSuite) TestClose(c *C) {
+	r := newReservations()
+	rv := mustAcquire(c, r, []string{"web"}, nil)
+	defer rv.release()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := r.acquire([]string{"web"}, nil)
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r.close()
+	select {
+	case err := <-errc:
+		c.Assert(err, Equals, errShuttingDown)
+	case <-time.After(time.Second):
+		c.Fatal("Waiting submit not canceled")
+	}
+	_, err := r.acquire([]string{"api"}, nil)
+	c.Assert(err, Equals, errShuttingDown)
+}
+
+// fakeDocker serves just enough of the Docker API to run a container of an
+// image already pulled.
+func fakeDocker(c *C) (*docker.Client, func()) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.Contains(r.URL.Path, "/images/"):
+			fmt.Fprint(w, `{"Id":"abc"}`)
+		case strings.HasSuffix(r.URL.Path, "/containers/create"):
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"Id":"c1"}`)
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	client, err := docker.NewClient(strings.Replace(ts.URL, "http://", "tcp://", 1))
+	c.Assert(err, IsNil)
+	return client, ts.Close
+}
+
+func (s *ReserveSuite) TestSubmitQueued(c *C) {
+	defer func(d time.Duration) { allocTimeout = d }(allocTimeout)
+	allocTimeout = 50 * time.Millisecond
+	client, done := fakeDocker(c)
+	defer done()
+	craft := &Craft{c: client, res: newReservations()}
+
+	rv := mustAcquire(c, craft.res, []string{"web"}, nil)
+	id, sc, err := streamConn.local()
+	c.Assert(err, IsNil)
+	go io.Copy(ioutil.Discard, sc)
+	errc := make(chan error, 1)
+	go func() {
+		req := SubmitRequest{Manifest: &docker.Manifest{Name: "web", Image: "busybox"}, StreamID: id}
+		errc <- craft.Submit(req, &SubmitResponse{})
+	}()
+
+	// the submit waits for another one longer than streams are kept
+	time.Sleep(3 * allocTimeout)
+	select {
+	case err := <-errc:
+		c.Fatalf("Submitted while held: %v", err)
+	default:
+	}
+	rv.release()
+	select {
+	case err := <-errc:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("Submit not finished after release")
+	}
+}
+
+func (s *ReserveSuite) TestAllocate(c *C) {
+	r := newReservations()
+	used := func() ([]int64, error) { return []int64{8000, 8002}, nil }
+	rv1 := mustAcquire(c, r, []string{"web"}, []int64{8001})
+	ports, err := rv1.allocate([][2]int64{{8000, 8010}, {8000, 8010}}, used)
+	c.Assert(err, IsNil)
+	c.Assert(ports, DeepEquals, []int64{8003, 8004})
+
+	rv2 := mustAcquire(c, r, []string{"api"}, nil)
+	ports, err = rv2.allocate([][2]int64{{8000, 8005}}, used)
+	c.Assert(err, IsNil)
+	c.Assert(ports, DeepEquals, []int64{8005})
+	_, err = rv2.allocate([][2]int64{{8000, 8005}}, used)
+	c.Assert(err, ErrorMatches, "No free host port in 8000-8005")
+
+	_, reserved := r.list()
+	c.Assert(reserved, DeepEquals, []int64{8001, 8003, 8004, 8005})
+	rv1.release()
+	rv2.release()
+	_, reserved = r.list()
+	c.Assert(reserved, HasLen, 0)
+}
+
+func (s *ReserveSuite) TestAllocateRetry(c *C) {
+	r := newReservations()
+	rv1 := mustAcquire(c, r, []string{"web"}, []int64{8000})
+	rv2 := mustAcquire(c, r, []string{"api"}, nil)
+	calls := 0
+	ports, err := rv2.allocate([][2]int64{{8000, 8001}}, func() ([]int64, error) {
+		calls++
+		if calls == 1 {
+			// the container of web starts listening and the submit ends
+			rv1.release()
+			return nil, nil
+		}
+		return []int64{8000}, nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 2)
+	c.Assert(ports, DeepEquals, []int64{8001})
+}
+
+func (s *ReserveSuite) TestManifestResources(c *C) {
+	m := &docker.Manifest{
+		Name:    "web",
+		Replace: "web-old",
+		Ports: []docker.PortSpec{
+			{Exposed: "80/tcp", HostPort: 80},
+			{Exposed: "443/tcp"},
+			{Exposed: "8080/tcp", HostPortMin: 8000, HostPortMax: 8100},
+		},
+	}
+	names, ports, ranges := manifestResources(m)
+	c.Assert(names, DeepEquals, []string{"web", "web-old"})
+	c.Assert(ports, DeepEquals, []int64{80})
+	c.Assert(ranges, DeepEquals, [][2]int64{{8000, 8100}})
+
+	m.Replace = "web"
+	names, _, _ = manifestResources(m)
+	c.Assert(names, DeepEquals, []string{"web"})
+}
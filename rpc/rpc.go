@@ -1,15 +1,25 @@
 package rpc
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/rpc"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/yosisa/craft/config"
+	"github.com/yosisa/craft/discovery"
+	"github.com/yosisa/craft/dns"
 	"github.com/yosisa/craft/docker"
 	"github.com/yosisa/craft/mux"
 )
@@ -20,11 +30,18 @@ var (
 	agentName string
 	labels    map[string]string
 	ipAddrs   []string
+	networks  map[string][]string
+	members   *memberList
+	stateMu   sync.RWMutex // guards settings changed by reloading
 )
 
 const (
 	chanRPC byte = iota
 	chanNewStream
+	chanGossip
+	chanSession
+	chanHello
+	chanOpenStream
 )
 
 type Empty struct{}
@@ -34,10 +51,17 @@ type Capability struct {
 	Agent      string
 	Labels     map[string]string
 	IPAddrs    []string
+	Networks   map[string][]string // addresses by network names of the agent
 	AllNames   []string
 	UsedNames  []string
 	UsedPorts  []int64
 	Containers map[string]*docker.ContainerInfo
+	Groups     []string // filled by the client from its inventory
+
+	// Names and host ports claimed by submits in progress. UsedPorts
+	// includes the reserved ports too.
+	ReservedNames []string
+	ReservedPorts []int64
 }
 
 type SubmitRequest struct {
@@ -47,10 +71,11 @@ type SubmitRequest struct {
 }
 
 type ExLink struct {
-	Name    string
-	Exposed string
-	Addr    string
-	Port    int
+	Name      string
+	Exposed   string
+	Addr      string
+	Port      int
+	Endpoints []string // host:port of all the containers linked
 }
 
 func (l *ExLink) Env() map[string]string {
@@ -59,100 +84,151 @@ func (l *ExLink) Env() map[string]string {
 	port, proto := parts[0], parts[1]
 	prefix := fmt.Sprintf("%s_PORT_%s_%s", name, port, strings.ToUpper(proto))
 	v := map[string]string{
-		name + "_PORT":    fmt.Sprintf("%s://%s:%d", proto, l.Addr, l.Port),
+		name + "_PORT":    fmt.Sprintf("%s://%s", proto, net.JoinHostPort(l.Addr, strconv.Itoa(l.Port))),
 		prefix + "_ADDR":  l.Addr,
 		prefix + "_PORT":  fmt.Sprintf("%d", l.Port),
 		prefix + "_PROTO": proto,
 	}
 	v[prefix] = v[name+"_PORT"]
+	if len(l.Endpoints) > 0 {
+		v[name+"_ENDPOINTS"] = strings.Join(l.Endpoints, ",")
+		v[prefix+"_ENDPOINTS"] = v[name+"_ENDPOINTS"]
+	}
 	return v
 }
 
 type SubmitResponse struct {
 	Agent string
+	Ports []docker.PortSpec `json:",omitempty"` // with allocated host ports
 }
 
 type Craft struct {
-	c  *docker.Client
-	lc chan struct{}
+	c   *docker.Client
+	res *reservations
 }
 
 func (c *Craft) Capability(req Empty, resp *Capability) error {
-	if !c.lockNoWait() {
+	if isDraining() {
 		return nil
 	}
-	defer c.unlock()
-
+	// read before the usage not to miss a submit finishing in between
+	resp.ReservedNames, resp.ReservedPorts = c.res.list()
 	ui, err := c.c.Usage()
 	if err != nil {
 		return err
 	}
 	resp.Available = true
-	resp.Agent = agentName
-	resp.Labels = labels
-	resp.IPAddrs = ipAddrs
+	resp.Agent, resp.Labels = currentAgent()
+	resp.IPAddrs, resp.Networks = currentAddrs()
 	resp.AllNames = ui.AllNames
 	resp.UsedNames = ui.UsedNames
 	resp.UsedPorts = ui.UsedPorts
+	for _, port := range resp.ReservedPorts {
+		if !containsPort(resp.UsedPorts, port) {
+			resp.UsedPorts = append(resp.UsedPorts, port)
+		}
+	}
 	resp.Containers = ui.Containers
 	return nil
 }
 
-func (c *Craft) Submit(req SubmitRequest, resp *SubmitResponse) error {
-	c.lock()
-	defer c.unlock()
+func (c *Craft) Submit(req SubmitRequest, resp *SubmitResponse) (err error) {
+	if err = beginOperation(); err != nil {
+		return err
+	}
+	defer endOperation()
+	// take the stream before waiting for other submits, or it may expire
 	w, err := streamConn.get(req.StreamID)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
+	names, ports, ranges := manifestResources(req.Manifest)
+	rv, err := c.res.acquire(names, ports)
+	if err != nil {
+		return err
+	}
+	defer rv.release()
+	if isDraining() {
+		// don't start while shutting down even if queued before
+		return errShuttingDown
+	}
+	start := time.Now()
+	defer func() {
+		submitDuration.WithLabelValues(outcome(err)).Observe(time.Since(start).Seconds())
+	}()
 
 	for _, exl := range req.ExLinks {
 		req.Manifest.MergeEnv(exl.Env())
 	}
-	if err := c.c.Run(req.Manifest, w); err != nil {
+	// resolve on a copy not to leak values into the audit log
+	m := *req.Manifest
+	if m.Env, err = resolveSecrets(m.Env); err != nil {
 		return err
 	}
-	resp.Agent = agentName
+	if m.Ports, err = c.allocatePorts(rv, m.Ports, ranges); err != nil {
+		return err
+	}
+	if err = c.c.Run(&m, newPullCounter(w)); err != nil {
+		return err
+	}
+	resp.Agent, _ = currentAgent()
+	resp.Ports = m.Ports
 	return nil
 }
 
-func (c *Craft) lock() {
-	<-c.lc
+// allocatePorts returns a copy of specs whose dynamic host ports are
+// allocated.
+func (c *Craft) allocatePorts(rv *reservation, specs []docker.PortSpec, ranges [][2]int64) ([]docker.PortSpec, error) {
+	if len(ranges) == 0 {
+		return specs, nil
+	}
+	allocated, err := rv.allocate(ranges, func() ([]int64, error) {
+		ui, err := c.c.Usage()
+		if err != nil {
+			return nil, err
+		}
+		return ui.UsedPorts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]docker.PortSpec, len(specs))
+	copy(out, specs)
+	for i := range out {
+		if out[i].Dynamic() {
+			out[i].HostPort, allocated = allocated[0], allocated[1:]
+		}
+	}
+	return out, nil
 }
 
-func (c *Craft) lockNoWait() bool {
-	select {
-	case <-c.lc:
-		return true
-	default:
-		return false
-	}
+type MembersResponse struct {
+	Members []Member
 }
 
-func (c *Craft) unlock() {
-	c.lc <- struct{}{}
+func (c *Craft) Members(req Empty, resp *MembersResponse) error {
+	resp.Members = members.list()
+	return nil
 }
 
 func ListenAndServe(c *config.Config) error {
-	agentName = c.AgentName
-	labels = c.Labels
-	ips, err := ListIPAddrs()
-	if err != nil {
+	if err := applyConfig(nil, c); err != nil {
 		return err
 	}
-	ipAddrs = ips
+	members = newMemberList(c.AgentName, c.Listen, gossipSeeds(c))
+	members.discover = discoverPeers(c.Discovery)
 
 	client, err := docker.NewClient(c.Docker)
 	if err != nil {
 		return err
 	}
 	craft := &Craft{
-		c:  client,
-		lc: make(chan struct{}, 1),
+		c:   client,
+		res: newReservations(),
 	}
-	craft.lc <- struct{}{}
 	rpc.Register(craft)
+	prometheus.MustRegister(newUsageCollector(client))
 
 	d, err := NewDocker(c.Docker)
 	if err != nil {
@@ -160,9 +236,13 @@ func ListenAndServe(c *config.Config) error {
 	}
 	rpc.Register(d)
 	rpc.Register(streamConn)
+	hostLabels = newLabeler(d.c)
+	// facts are ready on the first capability request
+	hostLabels.gatherFacts()
+	hostLabels.start(c.Devices, c.Probes)
 
 	mux.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) {
-		rpc.ServeConn(c)
+		rpc.ServeCodec(newServerCodec(c))
 	}))
 	mux.Handle(chanNewStream, mux.HandlerFunc(func(c net.Conn) {
 		if err := streamConn.put(c); err != nil {
@@ -170,14 +250,79 @@ func ListenAndServe(c *config.Config) error {
 			c.Close()
 		}
 	}))
+	mux.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) {
+		mux.ServeSession(c)
+	}))
+	mux.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) {
+		if err := serveHello(c); err != nil {
+			log.WithField("error", err).Error("Failed to handshake")
+		}
+	}))
+	mux.Handle(chanOpenStream, mux.HandlerFunc(func(c net.Conn) {
+		if err := streamConn.open(c); err != nil {
+			log.WithField("error", err).Error("Failed to open stream")
+		}
+	}))
+	mux.Handle(chanGossip, mux.HandlerFunc(func(c net.Conn) {
+		if err := members.handle(c); err != nil {
+			log.WithField("error", err).Debug("Failed to handle gossip")
+		}
+	}))
 
 	ln, err := net.Listen("tcp", c.Listen)
 	if err != nil {
 		return err
 	}
+	go members.run()
+	go watchConfig(c)
+	registry = newServiceRegistry(func(cap *Capability) error {
+		return craft.Capability(Empty{}, cap)
+	})
+	go registry.run()
+	if c.DNS != "" {
+		go func() {
+			if err := dns.Serve(c.DNS, ServiceDomain, registry.lookup); err != nil {
+				log.WithField("error", err).Error("Failed to serve DNS")
+			}
+		}()
+	}
+	if c.HTTP != "" {
+		go func() {
+			m := http.NewServeMux()
+			m.Handle("/metrics", promhttp.Handler())
+			m.Handle("/", NewGateway(craft, d, c.HTTPToken))
+			if err := http.ListenAndServe(c.HTTP, m); err != nil {
+				log.WithField("error", err).Error("Failed to serve HTTP")
+			}
+		}()
+	}
+	if c.Announce {
+		go func() {
+			announce := func() *discovery.Announcement {
+				name, labels := currentAgent()
+				return &discovery.Announcement{Name: name, Addr: c.Listen, Labels: labels}
+			}
+			if err := discovery.Serve(c.Discovery, announce); err != nil {
+				log.WithField("error", err).Error("Failed to announce agent")
+			}
+		}()
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigc
+		log.WithField("signal", sig).Info("Shutting down")
+		beginShutdown()
+		// submits waiting for others would never start
+		craft.res.close()
+		ln.Close()
+	}()
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if isDraining() {
+				break
+			}
 			fmt.Println(err)
 			continue
 		}
@@ -187,10 +332,39 @@ func ListenAndServe(c *config.Config) error {
 			}
 		}()
 	}
+	drain(c.ShutdownWait())
 	return nil
 }
 
+var (
+	sessions     = make(map[*rpc.Client]*mux.Session)
+	hellos       = make(map[*rpc.Client]*Hello)
+	legacyAgents = make(map[string]time.Time) // until sessions are tried again
+	sessionsMu   sync.Mutex
+	errNoSession = errors.New("Session not supported")
+	pingTimeout  = dialTimeout
+)
+
+// legacyExpiry is how long an agent without sessions is remembered, so that
+// it's found once upgraded.
+const legacyExpiry = 10 * time.Minute
+
+// Dial connects to the agent. The RPC and its streams are multiplexed over
+// a single connection unless the agent is too old to support it.
 func Dial(network, address string) (*rpc.Client, error) {
+	sessionsMu.Lock()
+	legacy := time.Now().Before(legacyAgents[address])
+	sessionsMu.Unlock()
+	if !legacy {
+		c, err := dialSession(network, address)
+		if err != errNoSession {
+			return c, err
+		}
+		sessionsMu.Lock()
+		legacyAgents[address] = time.Now().Add(legacyExpiry)
+		sessionsMu.Unlock()
+	}
+
 	conn, err := mux.DialTimeout(network, address, chanRPC, 5*time.Second)
 	if err != nil {
 		return nil, err
@@ -198,12 +372,70 @@ func Dial(network, address string) (*rpc.Client, error) {
 	return rpc.NewClient(conn), nil
 }
 
+func dialSession(network, address string) (*rpc.Client, error) {
+	conn, err := mux.DialTimeout(network, address, chanSession, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	s := mux.Client(conn)
+	if err = s.Ping(pingTimeout); err != nil {
+		s.Close()
+		// older agents close the connection of unknown type, while a
+		// timeout tells nothing about the agent
+		if err == mux.ErrSessionClosed {
+			err = errNoSession
+		}
+		return nil, err
+	}
+	hello, err := handshake(s)
+	if err != nil {
+		hello = sessionHello
+	} else if !hello.compatible() {
+		s.Close()
+		return nil, fmt.Errorf("Incompatible protocol version: agent %d-%d (%s), client %d-%d (%s)",
+			hello.MinProtocol, hello.Protocol, hello.Version, MinProtocolVersion, ProtocolVersion, Version)
+	}
+	rc, err := s.Dial(chanRPC)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	sc := &sessionConn{Conn: rc, s: s}
+	c := rpc.NewClient(sc)
+	sessionsMu.Lock()
+	sc.c = c
+	sessions[c] = s
+	hellos[c] = hello
+	sessionsMu.Unlock()
+	return c, nil
+}
+
+// sessionConn is the RPC connection in a session. Closing it closes the
+// session including all streams.
+type sessionConn struct {
+	net.Conn
+	s *mux.Session
+	c *rpc.Client
+}
+
+func (c *sessionConn) Close() error {
+	sessionsMu.Lock()
+	delete(sessions, c.c)
+	delete(hellos, c.c)
+	sessionsMu.Unlock()
+	c.Conn.Close()
+	return c.s.Close()
+}
+
 func Submit(address string, m *docker.Manifest, exlinks []*ExLink) (*SubmitResponse, error) {
 	c, err := Dial("tcp", address)
 	if err != nil {
 		return nil, err
 	}
 	defer c.Close()
+	if err = requireSubmitFeatures(c, address, m, exlinks); err != nil {
+		return nil, err
+	}
 
 	id, sc, err := AllocStream(c, address)
 	if err != nil {
@@ -228,6 +460,26 @@ func Submit(address string, m *docker.Manifest, exlinks []*ExLink) (*SubmitRespo
 	return &resp, err
 }
 
+// requireSubmitFeatures fails if the agent would ignore a part of the
+// request.
+func requireSubmitFeatures(c *rpc.Client, addr string, m *docker.Manifest, exlinks []*ExLink) error {
+	for _, p := range m.Ports {
+		if p.Dynamic() {
+			// older agents would let docker choose a port without reservation
+			if err := requireFeature(c, addr, FeaturePorts); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for _, l := range exlinks {
+		if len(l.Endpoints) > 0 {
+			return requireFeature(c, addr, FeatureEndpoints)
+		}
+	}
+	return nil
+}
+
 func CallAll(addrs []string, f func(c *rpc.Client, addr string) (interface{}, error)) (map[string]interface{}, error) {
 	var wg sync.WaitGroup
 	wg.Add(len(addrs))
@@ -21,4 +21,15 @@ func (s *ExLinkSuite) TestEnv(c *C) {
 		"API_PORT_80_TCP_PORT":  "80",
 		"API_PORT_80_TCP_PROTO": "tcp",
 	})
+
+	l.Endpoints = []string{"192.168.1.1:80", "192.168.1.2:8080"}
+	env := l.Env()
+	c.Assert(env["API_ENDPOINTS"], Equals, "192.168.1.1:80,192.168.1.2:8080")
+	c.Assert(env["API_PORT_80_TCP_ENDPOINTS"], Equals, "192.168.1.1:80,192.168.1.2:8080")
+	c.Assert(env["API_PORT"], Equals, "tcp://192.168.1.1:80")
+
+	l = ExLink{Name: "api", Exposed: "80/tcp", Addr: "fd00::1", Port: 80}
+	env = l.Env()
+	c.Assert(env["API_PORT"], Equals, "tcp://[fd00::1]:80")
+	c.Assert(env["API_PORT_80_TCP_ADDR"], Equals, "fd00::1")
 }
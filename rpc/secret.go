@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"errors"
+	"net/rpc"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/docker"
+	"github.com/yosisa/craft/secret"
+)
+
+var (
+	secrets          *secret.Store
+	errNoSecretStore = errors.New("Secret key is not configured on the agent")
+)
+
+// SetSecretRequest carries a value encrypted by the client.
+type SetSecretRequest struct {
+	Name  string
+	Value []byte
+}
+
+type SecretResponse struct {
+	Value []byte
+}
+
+type ListSecretsResponse struct {
+	Names []string
+}
+
+func (c *Craft) SetSecret(req SetSecretRequest, resp *Empty) error {
+	secrets := currentSecrets()
+	if secrets == nil {
+		return errNoSecretStore
+	}
+	return secrets.Set(req.Name, req.Value)
+}
+
+func (c *Craft) GetSecret(req string, resp *SecretResponse) error {
+	secrets := currentSecrets()
+	if secrets == nil {
+		return errNoSecretStore
+	}
+	v, ok := secrets.Get(req)
+	if !ok {
+		return errors.New("Unknown secret: " + req)
+	}
+	resp.Value = v
+	return nil
+}
+
+func (c *Craft) RemoveSecret(req string, resp *Empty) error {
+	secrets := currentSecrets()
+	if secrets == nil {
+		return errNoSecretStore
+	}
+	return secrets.Remove(req)
+}
+
+func (c *Craft) ListSecrets(req Empty, resp *ListSecretsResponse) error {
+	secrets := currentSecrets()
+	if secrets == nil {
+		return errNoSecretStore
+	}
+	resp.Names = secrets.Names()
+	return nil
+}
+
+// resolveSecrets returns a copy of env whose secret references are replaced
+// with the values.
+func resolveSecrets(env docker.Env) (docker.Env, error) {
+	secrets := currentSecrets()
+	if secrets == nil {
+		for _, v := range env {
+			if _, ok := secret.Name(v); ok {
+				return nil, errNoSecretStore
+			}
+		}
+		return env, nil
+	}
+	return secrets.Resolve(env)
+}
+
+func SetSecret(addrs []string, name string, value []byte) error {
+	_, err := CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		if err := requireFeature(c, addr, FeatureSecrets); err != nil {
+			return nil, err
+		}
+		req := SetSecretRequest{Name: name, Value: value}
+		err := c.Call("Craft.SetSecret", req, &Empty{})
+		if err == nil {
+			log.WithFields(log.Fields{"agent": addr, "secret": name}).Info("Secret stored")
+		}
+		return nil, err
+	})
+	return err
+}
+
+// GetSecret returns the encrypted value from the first agent which has it.
+func GetSecret(addrs []string, name string) ([]byte, error) {
+	var err error
+	for _, addr := range addrs {
+		var c *rpc.Client
+		if c, err = Dial("tcp", addr); err != nil {
+			continue
+		}
+		var resp SecretResponse
+		if err = requireFeature(c, addr, FeatureSecrets); err == nil {
+			err = c.Call("Craft.GetSecret", name, &resp)
+		}
+		c.Close()
+		if err == nil {
+			return resp.Value, nil
+		}
+	}
+	return nil, err
+}
+
+func RemoveSecret(addrs []string, name string) error {
+	_, err := CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		if err := requireFeature(c, addr, FeatureSecrets); err != nil {
+			return nil, err
+		}
+		err := c.Call("Craft.RemoveSecret", name, &Empty{})
+		if err == nil {
+			log.WithFields(log.Fields{"agent": addr, "secret": name}).Info("Secret removed")
+		}
+		return nil, err
+	})
+	return err
+}
+
+func ListSecrets(addrs []string) (map[string]interface{}, error) {
+	return CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		if err := requireFeature(c, addr, FeatureSecrets); err != nil {
+			return nil, err
+		}
+		var resp ListSecretsResponse
+		err := c.Call("Craft.ListSecrets", Empty{}, &resp)
+		return resp.Names, err
+	})
+}
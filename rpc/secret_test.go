@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"github.com/yosisa/craft/docker"
+	. "gopkg.in/check.v1"
+)
+
+type SecretSuite struct{}
+
+var _ = Suite(&SecretSuite{})
+
+func (s *SecretSuite) TestResolveWithoutStore(c *C) {
+	env := docker.Env{"PORT": "80"}
+	resolved, err := resolveSecrets(env)
+	c.Assert(err, IsNil)
+	c.Assert(resolved, DeepEquals, env)
+
+	_, err = resolveSecrets(docker.Env{"DB_PASSWORD": "secret://db"})
+	c.Assert(err, Equals, errNoSecretStore)
+}
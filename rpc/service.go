@@ -0,0 +1,253 @@
+package rpc
+
+import (
+	"net"
+	"net/rpc"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/dns"
+)
+
+const (
+	serviceInterval = 10 * time.Second
+	// ServiceDomain is the DNS domain of services, e.g. redis.craft.
+	ServiceDomain = "craft"
+)
+
+// Service is a published port of a running container in the cluster.
+type Service struct {
+	Name    string
+	Agent   string
+	Exposed string // container port such as 6379/tcp
+	Addr    string
+	Port    int64
+}
+
+// ServicesRequest selects services by the container name. All services are
+// returned if Name is empty.
+type ServicesRequest struct {
+	Name string
+}
+
+type ServicesResponse struct {
+	Services []Service
+	Updated  time.Time
+}
+
+var registry *serviceRegistry
+
+// serviceRegistry aggregates services of the cluster by asking members for
+// their capabilities periodically. Members are found through seeds,
+// configured agents or discovery (see gossipSeeds). Services of a member which
+// doesn't answer are kept until it's considered dead, while ones of a member
+// answering it's unavailable, e.g. shutting down, are dropped.
+type serviceRegistry struct {
+	local    func(*Capability) error
+	services map[string][]Service // by agent name
+	agents   map[string]string    // address of agents by name
+	updated  time.Time
+	m        sync.Mutex
+}
+
+func newServiceRegistry(local func(*Capability) error) *serviceRegistry {
+	return &serviceRegistry{
+		local:    local,
+		services: make(map[string][]Service),
+		agents:   make(map[string]string),
+	}
+}
+
+func (r *serviceRegistry) run() {
+	for {
+		r.refresh(members.list())
+		time.Sleep(serviceInterval)
+	}
+}
+
+func (r *serviceRegistry) refresh(list []Member) {
+	self, _ := currentAgent()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	caps := make(map[string]*Capability)
+	for _, m := range list {
+		if m.State == MemberDead {
+			continue
+		}
+		wg.Add(1)
+		go func(m Member) {
+			defer wg.Done()
+			var cap Capability
+			var err error
+			if m.Name == self {
+				err = r.local(&cap)
+			} else {
+				err = fetchCapability(m.Addr, &cap)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"error": err, "agent": m.Name}).Debug("Failed to get services")
+				return
+			}
+			mu.Lock()
+			caps[m.Name] = &cap
+			mu.Unlock()
+		}(m)
+	}
+	wg.Wait()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	services := make(map[string][]Service)
+	agents := make(map[string]string)
+	for _, m := range list {
+		if m.State == MemberDead {
+			continue
+		}
+		cap, ok := caps[m.Name]
+		if !ok {
+			services[m.Name], agents[m.Name] = r.services[m.Name], r.agents[m.Name]
+			continue
+		}
+		if !cap.Available {
+			if addr, ok := r.agents[m.Name]; ok {
+				agents[m.Name] = addr
+			}
+			continue
+		}
+		if len(cap.IPAddrs) > 0 {
+			agents[m.Name] = cap.IPAddrs[0]
+		}
+		services[m.Name] = capabilityServices(m.Name, cap)
+	}
+	r.services, r.agents = services, agents
+	r.updated = time.Now()
+}
+
+func fetchCapability(addr string, cap *Capability) error {
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Call("Craft.Capability", Empty{}, cap)
+}
+
+// capabilityServices returns published ports of containers. Ports bound to
+// any address are reachable at the first address of the agent.
+func capabilityServices(agent string, cap *Capability) []Service {
+	var out []Service
+	for name, ci := range cap.Containers {
+		for _, p := range ci.Ports {
+			addr := p.HostIP
+			if addr == "" || net.ParseIP(addr).IsUnspecified() {
+				if len(cap.IPAddrs) == 0 {
+					continue
+				}
+				addr = cap.IPAddrs[0]
+			}
+			out = append(out, Service{
+				Name:    name,
+				Agent:   agent,
+				Exposed: string(p.Exposed),
+				Addr:    addr,
+				Port:    p.HostPort,
+			})
+		}
+	}
+	return out
+}
+
+// list returns services of the name sorted by name, agent and port.
+func (r *serviceRegistry) list(name string) ([]Service, time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	var out []Service
+	for _, services := range r.services {
+		for _, s := range services {
+			if name == "" || s.Name == name {
+				out = append(out, s)
+			}
+		}
+	}
+	sort.Sort(byServiceName(out))
+	return out, r.updated
+}
+
+// lookup resolves a name relative to the service domain. "redis" returns all
+// published ports of redis, and "_6379._tcp.redis" the one exposed as
+// 6379/tcp. "agent1.node" returns the address of agent1, which is the target
+// of SRV records.
+func (r *serviceRegistry) lookup(name string) ([]dns.Record, bool) {
+	if agent := strings.TrimSuffix(name, ".node"); agent != name {
+		r.m.Lock()
+		defer r.m.Unlock()
+		for name, addr := range r.agents {
+			if strings.EqualFold(name, agent) {
+				return []dns.Record{{IP: net.ParseIP(addr)}}, true
+			}
+		}
+		return nil, false
+	}
+
+	var exposed string
+	if parts := strings.SplitN(name, ".", 3); len(parts) == 3 && strings.HasPrefix(parts[0], "_") && strings.HasPrefix(parts[1], "_") {
+		exposed = parts[0][1:] + "/" + parts[1][1:]
+		name = parts[2]
+	}
+	// names in queries are lower case
+	services, _ := r.list("")
+	var out []dns.Record
+	for _, s := range services {
+		if !strings.EqualFold(s.Name, name) || exposed != "" && s.Exposed != exposed {
+			continue
+		}
+		out = append(out, dns.Record{
+			IP:     net.ParseIP(s.Addr),
+			Port:   uint16(s.Port),
+			Target: s.Agent + ".node." + ServiceDomain + ".",
+		})
+	}
+	return out, len(out) > 0
+}
+
+type byServiceName []Service
+
+func (s byServiceName) Len() int      { return len(s) }
+func (s byServiceName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byServiceName) Less(i, j int) bool {
+	switch {
+	case s[i].Name != s[j].Name:
+		return s[i].Name < s[j].Name
+	case s[i].Agent != s[j].Agent:
+		return s[i].Agent < s[j].Agent
+	}
+	return s[i].Port < s[j].Port
+}
+
+func (c *Craft) Services(req ServicesRequest, resp *ServicesResponse) error {
+	resp.Services, resp.Updated = registry.list(req.Name)
+	return nil
+}
+
+// Services returns services of the cluster from the first agent answering.
+func Services(addrs []string, name string) (*ServicesResponse, error) {
+	var err error
+	for _, addr := range addrs {
+		var c *rpc.Client
+		if c, err = Dial("tcp", addr); err != nil {
+			continue
+		}
+		var resp ServicesResponse
+		if err = requireFeature(c, addr, FeatureServices); err == nil {
+			err = c.Call("Craft.Services", ServicesRequest{Name: name}, &resp)
+		}
+		c.Close()
+		if err == nil {
+			return &resp, nil
+		}
+	}
+	return nil, err
+}
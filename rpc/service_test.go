@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"net"
+
+	"github.com/yosisa/craft/dns"
+	"github.com/yosisa/craft/docker"
+	. "gopkg.in/check.v1"
+)
+
+type ServiceSuite struct{}
+
+var _ = Suite(&ServiceSuite{})
+
+func (s *ServiceSuite) SetUpTest(c *C) {
+	stateMu.Lock()
+	agentName = "agent1"
+	stateMu.Unlock()
+}
+
+func (s *ServiceSuite) TearDownTest(c *C) {
+	stateMu.Lock()
+	agentName = ""
+	stateMu.Unlock()
+}
+
+func testCapability(ports ...docker.PortSpec) *Capability {
+	specs := make([]*docker.PortSpec, len(ports))
+	for i := range ports {
+		specs[i] = &ports[i]
+	}
+	return &Capability{
+		Available: true,
+		IPAddrs:   []string{"10.0.0.1"},
+		Containers: map[string]*docker.ContainerInfo{
+			"Redis": {Ports: specs},
+			"batch": {},
+		},
+	}
+}
+
+func (s *ServiceSuite) TestRefresh(c *C) {
+	local := testCapability(
+		docker.PortSpec{Exposed: "6379/tcp", HostIP: "0.0.0.0", HostPort: 49153},
+		docker.PortSpec{Exposed: "16379/tcp", HostIP: "127.0.0.1", HostPort: 16379},
+	)
+	r := newServiceRegistry(func(cap *Capability) error {
+		*cap = *local
+		return nil
+	})
+	// agent2 is unreachable and agent3 is dead
+	r.services["agent2"] = []Service{{Name: "web", Agent: "agent2", Exposed: "80/tcp", Addr: "10.0.0.2", Port: 80}}
+	r.agents["agent2"] = "10.0.0.2"
+	r.services["agent3"] = []Service{{Name: "web", Agent: "agent3", Exposed: "80/tcp", Addr: "10.0.0.3", Port: 80}}
+	r.refresh([]Member{
+		{Name: "agent1", Addr: ":7300", State: MemberAlive},
+		{Name: "agent2", Addr: "127.0.0.1:1", State: MemberSuspect},
+		{Name: "agent3", Addr: "127.0.0.1:1", State: MemberDead},
+	})
+
+	services, updated := r.list("")
+	c.Assert(updated.IsZero(), Equals, false)
+	c.Assert(services, DeepEquals, []Service{
+		{Name: "Redis", Agent: "agent1", Exposed: "16379/tcp", Addr: "127.0.0.1", Port: 16379},
+		{Name: "Redis", Agent: "agent1", Exposed: "6379/tcp", Addr: "10.0.0.1", Port: 49153},
+		{Name: "web", Agent: "agent2", Exposed: "80/tcp", Addr: "10.0.0.2", Port: 80},
+	})
+	services, _ = r.list("web")
+	c.Assert(services, HasLen, 1)
+}
+
+func (s *ServiceSuite) TestRefreshUnavailable(c *C) {
+	local := testCapability(docker.PortSpec{Exposed: "6379/tcp", HostPort: 49153})
+	r := newServiceRegistry(func(cap *Capability) error {
+		*cap = *local
+		return nil
+	})
+	list := []Member{{Name: "agent1", Addr: ":7300", State: MemberAlive}}
+	r.refresh(list)
+	services, _ := r.list("")
+	c.Assert(services, HasLen, 1)
+
+	// the agent is shutting down
+	local = &Capability{Available: false}
+	r.refresh(list)
+	services, _ = r.list("")
+	c.Assert(services, HasLen, 0)
+	records, ok := r.lookup("agent1.node")
+	c.Assert(ok, Equals, true)
+	c.Assert(records, DeepEquals, []dns.Record{{IP: net.ParseIP("10.0.0.1")}})
+}
+
+func (s *ServiceSuite) TestLookup(c *C) {
+	r := newServiceRegistry(nil)
+	r.services["agent1"] = capabilityServices("agent1", testCapability(
+		docker.PortSpec{Exposed: "6379/tcp", HostPort: 49153},
+		docker.PortSpec{Exposed: "16379/tcp", HostPort: 16379},
+	))
+	r.agents["agent1"] = "10.0.0.1"
+
+	records, ok := r.lookup("redis")
+	c.Assert(ok, Equals, true)
+	c.Assert(records, DeepEquals, []dns.Record{
+		{IP: net.ParseIP("10.0.0.1"), Port: 16379, Target: "agent1.node.craft."},
+		{IP: net.ParseIP("10.0.0.1"), Port: 49153, Target: "agent1.node.craft."},
+	})
+
+	records, ok = r.lookup("_6379._tcp.redis")
+	c.Assert(ok, Equals, true)
+	c.Assert(records, DeepEquals, []dns.Record{
+		{IP: net.ParseIP("10.0.0.1"), Port: 49153, Target: "agent1.node.craft."},
+	})
+
+	records, ok = r.lookup("agent1.node")
+	c.Assert(ok, Equals, true)
+	c.Assert(records, DeepEquals, []dns.Record{{IP: net.ParseIP("10.0.0.1")}})
+
+	for _, name := range []string{"batch", "_80._tcp.redis", "agent2.node", "unknown"} {
+		_, ok = r.lookup(name)
+		c.Assert(ok, Equals, false, Commentf(name))
+	}
+}
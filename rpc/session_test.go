@@ -0,0 +1,279 @@
+package rpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yosisa/craft/mux"
+)
+
+type Echo struct{}
+
+type EchoRequest struct {
+	Message  string
+	StreamID uint32
+}
+
+func (e *Echo) Echo(req EchoRequest, resp *Empty) error {
+	w, err := streamConn.get(req.StreamID)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.Write([]byte(req.Message))
+	return err
+}
+
+func serveMux(t *testing.T, m *mux.Mux) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.Dispatch(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func init() {
+	rpc.Register(&Echo{})
+	rpc.Register(streamConn)
+}
+
+func echo(t *testing.T, addr string) *rpc.Client {
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, sc, err := AllocStream(c, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Call("Echo.Echo", EchoRequest{"hello", id}, &Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "hello" {
+		t.Fatalf("unexpected message: %s", s)
+	}
+	return c
+}
+
+func TestDialSession(t *testing.T) {
+	m := &mux.Mux{}
+	m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+	m.Handle(chanNewStream, mux.HandlerFunc(func(c net.Conn) { streamConn.put(c) }))
+	m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) { m.ServeSession(c) }))
+	m.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) { serveHello(c) }))
+	m.Handle(chanOpenStream, mux.HandlerFunc(func(c net.Conn) { streamConn.open(c) }))
+	addr := serveMux(t, m)
+
+	c := echo(t, addr)
+	sessionsMu.Lock()
+	_, ok := sessions[c]
+	sessionsMu.Unlock()
+	if !ok {
+		t.Fatal("session must be used")
+	}
+	if h := agentHello(c); h.Version != Version || h.Protocol != ProtocolVersion {
+		t.Fatalf("unexpected hello: %+v", h)
+	}
+	if err := requireFeature(c, addr, FeatureBuild); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	sessionsMu.Lock()
+	_, ok = sessions[c]
+	sessionsMu.Unlock()
+	if ok {
+		t.Fatal("session must be removed")
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	// streams are opened only in the session
+	m := &mux.Mux{}
+	m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) { m.ServeSession(c) }))
+	m.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) { serveHello(c) }))
+	m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+	m.Handle(chanOpenStream, mux.HandlerFunc(func(c net.Conn) { streamConn.open(c) }))
+	addr := serveMux(t, m)
+
+	c := echo(t, addr)
+	c.Close()
+}
+
+func TestOpenStreamLifetime(t *testing.T) {
+	// set before serving not to race with handlers
+	defer func(d time.Duration) { allocTimeout = d }(allocTimeout)
+	allocTimeout = 50 * time.Millisecond
+	m := &mux.Mux{}
+	m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) { m.ServeSession(c) }))
+	m.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) { serveHello(c) }))
+	m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+	m.Handle(chanOpenStream, mux.HandlerFunc(func(c net.Conn) { streamConn.open(c) }))
+	addr := serveMux(t, m)
+
+	c, err := Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, sc, err := AllocStream(c, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stream opened in the session doesn't expire
+	time.Sleep(3 * allocTimeout)
+	if err = c.Call("Echo.Echo", EchoRequest{"hello", id}, &Empty{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(sc); err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected message: %s %v", b, err)
+	}
+
+	// but is released when the session is closed
+	if id, _, err = AllocStream(c, addr); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	for i := 0; ; i++ {
+		if _, err = streamConn.getChan(id); err != nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("stream not released with the session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialLegacy(t *testing.T) {
+	m := &mux.Mux{}
+	m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+	m.Handle(chanNewStream, mux.HandlerFunc(func(c net.Conn) { streamConn.put(c) }))
+	addr := serveMux(t, m)
+
+	c := echo(t, addr)
+	defer c.Close()
+	sessionsMu.Lock()
+	_, ok := sessions[c]
+	legacy := time.Now().Before(legacyAgents[addr])
+	sessionsMu.Unlock()
+	if ok || !legacy {
+		t.Fatal("legacy protocol must be used")
+	}
+	if _, ok := requireFeature(c, addr, FeatureBuild).(*FeatureError); !ok {
+		t.Fatal("legacy agent must not support build")
+	}
+}
+
+func TestDialLegacyExpiry(t *testing.T) {
+	m := &mux.Mux{}
+	m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+	m.Handle(chanNewStream, mux.HandlerFunc(func(c net.Conn) { streamConn.put(c) }))
+	m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) { m.ServeSession(c) }))
+	m.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) { serveHello(c) }))
+	m.Handle(chanOpenStream, mux.HandlerFunc(func(c net.Conn) { streamConn.open(c) }))
+	addr := serveMux(t, m)
+
+	// the agent was legacy and has been upgraded since then
+	sessionsMu.Lock()
+	legacyAgents[addr] = time.Now().Add(time.Minute)
+	sessionsMu.Unlock()
+	c := echo(t, addr)
+	defer c.Close()
+	sessionsMu.Lock()
+	_, ok := sessions[c]
+	sessionsMu.Unlock()
+	if ok {
+		t.Fatal("legacy protocol must be remembered")
+	}
+
+	sessionsMu.Lock()
+	legacyAgents[addr] = time.Now()
+	sessionsMu.Unlock()
+	c = echo(t, addr)
+	defer c.Close()
+	sessionsMu.Lock()
+	_, ok = sessions[c]
+	sessionsMu.Unlock()
+	if !ok {
+		t.Fatal("session must be used after the flag expires")
+	}
+}
+
+func TestDialPingTimeout(t *testing.T) {
+	defer func(d time.Duration) { pingTimeout = d }(pingTimeout)
+	pingTimeout = 100 * time.Millisecond
+	block := make(chan struct{})
+	defer close(block)
+	m := &mux.Mux{}
+	m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) {
+		// a busy agent which doesn't answer in time
+		<-block
+		c.Close()
+	}))
+	addr := serveMux(t, m)
+
+	if _, err := Dial("tcp", addr); err != mux.ErrTimeout {
+		t.Fatalf("expected timeout, but %v", err)
+	}
+	sessionsMu.Lock()
+	_, legacy := legacyAgents[addr]
+	sessionsMu.Unlock()
+	if legacy {
+		t.Fatal("timeout must not mark the agent legacy")
+	}
+}
+
+func TestDialIncompatible(t *testing.T) {
+	data := []struct {
+		protocol    int
+		minProtocol int
+		compatible  bool
+	}{
+		{ProtocolVersion, 0, true},
+		{ProtocolVersion + 1, ProtocolVersion, true},
+		{ProtocolVersion + 1, MinProtocolVersion, true},
+		{ProtocolVersion + 1, 0, false},
+		{ProtocolVersion + 2, ProtocolVersion + 1, false},
+		{MinProtocolVersion - 1, 0, false},
+	}
+	for _, test := range data {
+		hello := &Hello{Version: "9.0.0", Protocol: test.protocol, MinProtocol: test.minProtocol}
+		m := &mux.Mux{}
+		m.Handle(chanRPC, mux.HandlerFunc(func(c net.Conn) { rpc.ServeConn(c) }))
+		m.Handle(chanSession, mux.HandlerFunc(func(c net.Conn) { m.ServeSession(c) }))
+		m.Handle(chanHello, mux.HandlerFunc(func(c net.Conn) {
+			defer c.Close()
+			var h Hello
+			json.NewDecoder(c).Decode(&h)
+			json.NewEncoder(c).Encode(hello)
+		}))
+		addr := serveMux(t, m)
+
+		c, err := Dial("tcp", addr)
+		if test.compatible {
+			if err != nil {
+				t.Fatalf("%+v: %v", test, err)
+			}
+			c.Close()
+		} else if err == nil || !strings.Contains(err.Error(), "Incompatible") {
+			t.Fatalf("%+v: expected incompatible protocol error, but %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var errShuttingDown = errors.New("Agent is shutting down")
+
+var (
+	draining bool
+	inflight sync.WaitGroup
+	opMu     sync.RWMutex // orders registrations before waiting
+)
+
+// beginOperation registers an operation which must finish before the agent
+// exits. It fails while shutting down.
+func beginOperation() error {
+	opMu.RLock()
+	defer opMu.RUnlock()
+	if draining {
+		return errShuttingDown
+	}
+	inflight.Add(1)
+	return nil
+}
+
+func endOperation() {
+	inflight.Done()
+}
+
+func isDraining() bool {
+	opMu.RLock()
+	defer opMu.RUnlock()
+	return draining
+}
+
+// beginShutdown makes the agent unavailable and rejects new operations.
+func beginShutdown() {
+	opMu.Lock()
+	draining = true
+	opMu.Unlock()
+}
+
+// drain waits for operations in flight up to the timeout, then closes
+// streams left open such as logs and exec.
+func drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("All operations finished")
+	case <-time.After(timeout):
+		log.WithField("timeout", timeout).Warning("Timed out waiting for operations to finish")
+	}
+	openStreams.closeAll()
+	if al := currentAuditor(); al != nil {
+		al.close()
+	}
+}
+
+// streamSet keeps stream connections in use to close them on shutdown.
+type streamSet struct {
+	conns map[*trackedConn]struct{}
+	m     sync.Mutex
+}
+
+var openStreams = &streamSet{conns: make(map[*trackedConn]struct{})}
+
+func (s *streamSet) add(c *trackedConn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.conns[c] = struct{}{}
+}
+
+func (s *streamSet) remove(c *trackedConn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.conns, c)
+}
+
+func (s *streamSet) closeAll() {
+	s.m.Lock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.m.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"net"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShutdownSuite struct{}
+
+var _ = Suite(&ShutdownSuite{})
+
+func (s *ShutdownSuite) TearDownTest(c *C) {
+	opMu.Lock()
+	draining = false
+	opMu.Unlock()
+}
+
+func (s *ShutdownSuite) TestDrain(c *C) {
+	c.Assert(beginOperation(), IsNil)
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	tc := newTrackedConn(sc)
+
+	beginShutdown()
+	c.Assert(isDraining(), Equals, true)
+	c.Assert(beginOperation(), Equals, errShuttingDown)
+
+	var cap Capability
+	c.Assert((&Craft{}).Capability(Empty{}, &cap), IsNil)
+	c.Assert(cap.Available, Equals, false)
+
+	finished := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		finished <- time.Now()
+		endOperation()
+	}()
+	drain(time.Second)
+	c.Assert(time.Now().Before(<-finished), Equals, false)
+
+	// the stream is closed
+	_, err := tc.Write([]byte("x"))
+	c.Assert(err, NotNil)
+	openStreams.m.Lock()
+	defer openStreams.m.Unlock()
+	c.Assert(openStreams.conns, HasLen, 0)
+}
+
+func (s *ShutdownSuite) TestDrainTimeout(c *C) {
+	c.Assert(beginOperation(), IsNil)
+	defer endOperation()
+	beginShutdown()
+	start := time.Now()
+	drain(50 * time.Millisecond)
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+}
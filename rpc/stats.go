@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/nsf/termbox-go"
+)
+
+type ContainerStats struct {
+	ID         string
+	Name       string
+	CPUPercent float64
+	MemUsage   uint64
+	MemLimit   uint64
+	NetRx      uint64
+	NetTx      uint64
+	Read       time.Time
+}
+
+func newContainerStats(id, name string, s, prev *docker.Stats) *ContainerStats {
+	pre := s.PreCPUStats
+	if pre.SystemCPUUsage == 0 && prev != nil {
+		// older docker doesn't report the previous cpu usage
+		pre = prev.CPUStats
+	}
+	var cpu float64
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(pre.CPUUsage.TotalUsage)
+	sysDelta := float64(s.CPUStats.SystemCPUUsage) - float64(pre.SystemCPUUsage)
+	if pre.SystemCPUUsage > 0 && cpuDelta > 0 && sysDelta > 0 {
+		cpu = cpuDelta / sysDelta * float64(len(s.CPUStats.CPUUsage.PercpuUsage)) * 100
+	}
+	return &ContainerStats{
+		ID:         id,
+		Name:       name,
+		CPUPercent: cpu,
+		MemUsage:   s.MemoryStats.Usage,
+		MemLimit:   s.MemoryStats.Limit,
+		NetRx:      s.Network.RxBytes,
+		NetTx:      s.Network.TxBytes,
+		Read:       s.Read,
+	}
+}
+
+func (s *ContainerStats) MemPercent() float64 {
+	if s.MemLimit == 0 {
+		return 0
+	}
+	return float64(s.MemUsage) / float64(s.MemLimit) * 100
+}
+
+type statsTable struct {
+	stats        map[string]map[string]*ContainerStats
+	m            sync.Mutex
+	drawRequests chan struct{}
+}
+
+func newStatsTable() *statsTable {
+	return &statsTable{
+		stats:        make(map[string]map[string]*ContainerStats),
+		drawRequests: make(chan struct{}, 1),
+	}
+}
+
+func (t *statsTable) add(addr string, s *ContainerStats) {
+	t.m.Lock()
+	if t.stats[addr] == nil {
+		t.stats[addr] = make(map[string]*ContainerStats)
+	}
+	t.stats[addr][s.ID] = s
+	t.m.Unlock()
+	select {
+	case t.drawRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (t *statsTable) show() {
+	for _ = range t.drawRequests {
+		t.m.Lock()
+		termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+		var addrs []string
+		for addr := range t.stats {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		var row int
+		for _, addr := range addrs {
+			writeLine(row, fmt.Sprintf("[%s]", addr))
+			writeLine(row+1, fmt.Sprintf("  %-20s %8s   %-21s %8s   %s",
+				"NAME", "CPU %", "MEM USAGE / LIMIT", "MEM %", "NET I/O"))
+			row += 2
+			var ids []string
+			for id := range t.stats[addr] {
+				ids = append(ids, id)
+			}
+			sort.Sort(byStatsName{ids, t.stats[addr]})
+			for _, id := range ids {
+				s := t.stats[addr][id]
+				writeLine(row, fmt.Sprintf("  %-20s %7.2f%%   %-21s %7.2f%%   %s / %s",
+					s.Name, s.CPUPercent,
+					humanize.Bytes(s.MemUsage)+" / "+humanize.Bytes(s.MemLimit),
+					s.MemPercent(), humanize.Bytes(s.NetRx), humanize.Bytes(s.NetTx)))
+				row++
+			}
+			row++
+		}
+		t.m.Unlock()
+		termbox.Flush()
+	}
+}
+
+func (t *statsTable) close() {
+	close(t.drawRequests)
+}
+
+type byStatsName struct {
+	ids   []string
+	stats map[string]*ContainerStats
+}
+
+func (s byStatsName) Len() int {
+	return len(s.ids)
+}
+
+func (s byStatsName) Swap(i, j int) {
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+}
+
+func (s byStatsName) Less(i, j int) bool {
+	return s.stats[s.ids[i]].Name < s.stats[s.ids[j]].Name
+}
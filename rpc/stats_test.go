@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerStats(t *testing.T) {
+	var prev, s docker.Stats
+	prev.CPUStats.CPUUsage.TotalUsage = 100
+	prev.CPUStats.SystemCPUUsage = 1000
+	s.CPUStats.CPUUsage.TotalUsage = 150
+	s.CPUStats.CPUUsage.PercpuUsage = []uint64{75, 75}
+	s.CPUStats.SystemCPUUsage = 1200
+	s.MemoryStats.Usage = 256
+	s.MemoryStats.Limit = 1024
+
+	cs := newContainerStats("id", "api", &s, nil)
+	if cs.CPUPercent != 0 {
+		t.Errorf("got %f, expected 0 without previous usage", cs.CPUPercent)
+	}
+	cs = newContainerStats("id", "api", &s, &prev)
+	if cs.CPUPercent != 50 {
+		t.Errorf("got %f, expected 50", cs.CPUPercent)
+	}
+	if v := cs.MemPercent(); v != 25 {
+		t.Errorf("got %f, expected 25", v)
+	}
+
+	s.PreCPUStats = prev.CPUStats
+	cs = newContainerStats("id", "api", &s, nil)
+	if cs.CPUPercent != 50 {
+		t.Errorf("got %f, expected 50 using pre cpu stats", cs.CPUPercent)
+	}
+}
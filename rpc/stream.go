@@ -7,18 +7,26 @@ import (
 	"net"
 	"sync"
 	"time"
+
+	"github.com/yosisa/craft/mux"
 )
 
-const allocTimeout = 10 * time.Second
+// allocTimeout is how long an allocated stream waits to be taken, except
+// ones opened in sessions.
+var allocTimeout = 10 * time.Second
 
 type StreamConn struct {
-	p map[uint32]chan net.Conn
-	m sync.RWMutex
+	p       map[uint32]chan net.Conn
+	owners  map[uint32]*mux.Session // streams opened in sessions
+	watched map[*mux.Session]bool
+	m       sync.RWMutex
 }
 
 func NewStreamConn() *StreamConn {
 	return &StreamConn{
-		p: make(map[uint32]chan net.Conn),
+		p:       make(map[uint32]chan net.Conn),
+		owners:  make(map[uint32]*mux.Session),
+		watched: make(map[*mux.Session]bool),
 	}
 }
 
@@ -27,19 +35,72 @@ type AllocResponse struct {
 }
 
 func (s *StreamConn) Alloc(req Empty, resp *AllocResponse) error {
+	resp.ID, _ = s.alloc()
+	return nil
+}
+
+// alloc allocates a stream which is released unless taken in allocTimeout.
+func (s *StreamConn) alloc() (uint32, chan net.Conn) {
+	id, c := s.add(nil)
+	time.AfterFunc(allocTimeout, func() { s.release(id) })
+	return id, c
+}
+
+// add allocates a stream. If owner is given, the stream lives until it's
+// taken or the session is closed.
+func (s *StreamConn) add(owner *mux.Session) (uint32, chan net.Conn) {
 	s.m.Lock()
 	defer s.m.Unlock()
 	for {
 		id := rand.Uint32()
 		if _, ok := s.p[id]; !ok {
-			s.p[id] = make(chan net.Conn, 1)
-			resp.ID = id
-			time.AfterFunc(allocTimeout, func() { s.release(id) })
-			return nil
+			c := make(chan net.Conn, 1)
+			s.p[id] = c
+			if owner != nil {
+				s.owners[id] = owner
+				if !s.watched[owner] {
+					s.watched[owner] = true
+					go s.watch(owner)
+				}
+			}
+			return id, c
+		}
+	}
+}
+
+// watch releases streams of the session not taken until it's closed.
+func (s *StreamConn) watch(owner *mux.Session) {
+	<-owner.Done()
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.watched, owner)
+	for id, o := range s.owners {
+		if o == owner {
+			s.releaseLocked(id)
 		}
 	}
 }
 
+// open allocates a stream for the connection opened by the client in its
+// session, and tells the id back. The stream is kept while the session is
+// alive, since the call using it may wait for a while, e.g. for another
+// submit.
+func (s *StreamConn) open(conn net.Conn) error {
+	var id uint32
+	var c chan net.Conn
+	if st, ok := conn.(*mux.Stream); ok {
+		id, c = s.add(st.Session())
+	} else {
+		id, c = s.alloc()
+	}
+	c <- conn
+	if err := binary.Write(conn, binary.BigEndian, id); err != nil {
+		s.release(id)
+		return err
+	}
+	return nil
+}
+
 func (s *StreamConn) put(conn net.Conn) error {
 	var id uint32
 	if err := binary.Read(conn, binary.BigEndian, &id); err != nil {
@@ -53,6 +114,15 @@ func (s *StreamConn) put(conn net.Conn) error {
 	return nil
 }
 
+// local allocates a stream whose other end is returned to the agent itself.
+// It's used to serve requests not coming through RPC.
+func (s *StreamConn) local() (uint32, net.Conn, error) {
+	id, c := s.alloc()
+	c1, c2 := net.Pipe()
+	c <- c1
+	return id, c2, nil
+}
+
 func (s *StreamConn) get(id uint32) (net.Conn, error) {
 	c, err := s.getChan(id)
 	if err != nil {
@@ -63,7 +133,7 @@ func (s *StreamConn) get(id uint32) (net.Conn, error) {
 		return nil, fmt.Errorf("Timeout: acquiring stream connection: %d", id)
 	}
 	s.release(id)
-	return conn, nil
+	return newTrackedConn(conn), nil
 }
 
 func (s *StreamConn) getChan(id uint32) (chan net.Conn, error) {
@@ -79,6 +149,11 @@ func (s *StreamConn) getChan(id uint32) (chan net.Conn, error) {
 func (s *StreamConn) release(id uint32) {
 	s.m.Lock()
 	defer s.m.Unlock()
+	s.releaseLocked(id)
+}
+
+func (s *StreamConn) releaseLocked(id uint32) {
+	delete(s.owners, id)
 	if c, ok := s.p[id]; ok {
 		delete(s.p, id)
 		select {
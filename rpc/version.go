@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/rpc"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/mux"
+)
+
+// Version is the version of craft. It can be set at build time using
+// -ldflags "-X github.com/yosisa/craft/rpc.Version=..."
+var Version = "0.1.0-dev"
+
+// ProtocolVersion is increased when RPC requests or responses change
+// incompatibly, since gob doesn't tell anything about that. Additions which
+// older peers can do without are told by features instead.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest protocol version still spoken.
+const MinProtocolVersion = 1
+
+const (
+	FeatureSession   = "session"
+	FeatureBuild     = "build"
+	FeatureEvents    = "events"
+	FeatureStats     = "stats"
+	FeatureMembers   = "members"
+	FeatureAudit     = "audit"
+	FeatureSecrets   = "secrets"
+	FeaturePorts     = "dynamic_ports"
+	FeatureServices  = "services"
+	FeatureEndpoints = "endpoints"
+	// FeatureOpenStream tells streams are opened in the session without
+	// allocating by RPC.
+	FeatureOpenStream = "open_stream"
+)
+
+var features = []string{FeatureSession, FeatureBuild, FeatureEvents, FeatureStats, FeatureMembers, FeatureAudit, FeatureSecrets, FeaturePorts, FeatureServices, FeatureEndpoints, FeatureOpenStream}
+
+// Hello is exchanged by client and agent on connect. It's encoded in JSON
+// to be readable by any version. Peers speak any protocol version between
+// MinProtocol and Protocol. User is recorded in the audit log of the agent.
+type Hello struct {
+	Version     string
+	Protocol    int
+	MinProtocol int
+	Features    []string
+	User        string
+}
+
+func localHello() *Hello {
+	return &Hello{Version: Version, Protocol: ProtocolVersion, MinProtocol: MinProtocolVersion,
+		Features: features, User: currentUser()}
+}
+
+// compatible reports whether the peer speaks a protocol version in common.
+func (h *Hello) compatible() bool {
+	min := h.MinProtocol
+	if min == 0 {
+		// peers which don't tell it speak their own version only
+		min = h.Protocol
+	}
+	return h.Protocol >= MinProtocolVersion && min <= ProtocolVersion
+}
+
+func (h *Hello) Supports(feature string) bool {
+	return contains(h.Features, feature)
+}
+
+var (
+	// legacyHello describes agents which support neither sessions nor the
+	// handshake.
+	legacyHello = &Hello{Version: "unknown"}
+	// sessionHello describes agents which support sessions but not the
+	// handshake.
+	sessionHello = &Hello{Version: "unknown", Features: []string{FeatureSession}}
+)
+
+func handshake(s *mux.Session) (*Hello, error) {
+	c, err := s.Dial(chanHello)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err = json.NewEncoder(c).Encode(localHello()); err != nil {
+		return nil, err
+	}
+	var h Hello
+	if err = json.NewDecoder(c).Decode(&h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func serveHello(c net.Conn) error {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	var h Hello
+	if err := json.NewDecoder(c).Decode(&h); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"version": h.Version, "protocol": h.Protocol, "user": h.User}).Debug("Client connected")
+	setIdentity(c, h.User)
+	return json.NewEncoder(c).Encode(localHello())
+}
+
+// agentHello returns what the agent told on connect.
+func agentHello(c *rpc.Client) *Hello {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if h, ok := hellos[c]; ok {
+		return h
+	}
+	return legacyHello
+}
+
+// FeatureError is returned when the agent doesn't support a feature the
+// client needs.
+type FeatureError struct {
+	Agent   string
+	Version string
+	Feature string
+}
+
+func (e *FeatureError) Error() string {
+	return fmt.Sprintf("Agent %s (version %s) doesn't support %s, please upgrade it", e.Agent, e.Version, e.Feature)
+}
+
+// requireFeature returns an error if the agent doesn't support the feature.
+func requireFeature(c *rpc.Client, addr, feature string) error {
+	if h := agentHello(c); !h.Supports(feature) {
+		return &FeatureError{addr, h.Version, feature}
+	}
+	return nil
+}
+
+// Versions returns Hello of agents.
+func Versions(addrs []string) (map[string]interface{}, error) {
+	return CallAll(addrs, func(c *rpc.Client, addr string) (interface{}, error) {
+		return agentHello(c), nil
+	})
+}
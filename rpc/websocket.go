@@ -0,0 +1,248 @@
+package rpc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The server side of WebSocket (RFC 6455), enough to carry streams of
+// interactive exec.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	// maxMessageSize limits a message from clients, which is stdin.
+	maxMessageSize = 1 << 20
+)
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const (
+	wsCloseNormal   = 1000
+	wsCloseProtocol = 1002
+	wsCloseTooBig   = 1009
+	wsCloseInternal = 1011
+)
+
+var errWebSocketRequired = errors.New("WebSocket required")
+
+func isWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContains reports whether the comma separated header has the token.
+func headerContains(h http.Header, key, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(key)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// upgradeWebSocket completes the opening handshake and takes over the
+// connection. Nothing is written to w on error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" {
+		return nil, errMethodNotAllowed
+	}
+	if !isWebSocket(r) {
+		return nil, errWebSocketRequired
+	}
+	if !sameOrigin(r) {
+		return nil, errCrossOrigin
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		return nil, fmt.Errorf("Unsupported WebSocket version: %s", v)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("Sec-WebSocket-Key required")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("WebSocket not supported")
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", wsAccept(key))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWSConn(conn, buf.Reader), nil
+}
+
+type wsConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	m      sync.Mutex // serializes writes
+	closed bool
+}
+
+func newWSConn(conn net.Conn, r *bufio.Reader) *wsConn {
+	if r == nil {
+		r = bufio.NewReader(conn)
+	}
+	return &wsConn{conn: conn, r: r}
+}
+
+// WriteMessage sends p as a single unmasked frame.
+func (c *wsConn) WriteMessage(op byte, p []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	return c.writeFrame(op, p)
+}
+
+func (c *wsConn) writeFrame(op byte, p []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(p); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = hdr[:4]
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = hdr[:10]
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	_, err := c.conn.Write(append(hdr, p...))
+	return err
+}
+
+// ReadMessage returns the next data message. Control frames are handled
+// meanwhile, and io.EOF is returned once the client closes.
+func (c *wsConn) ReadMessage() (byte, []byte, error) {
+	var op byte
+	var msg []byte
+	for {
+		fin, fop, p, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case wsPing:
+			if err = c.WriteMessage(wsPong, p); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, c.fail(wsCloseProtocol, "Unexpected continuation frame")
+			}
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, c.fail(wsCloseProtocol, "Unfinished message")
+			}
+			op = fop
+		default:
+			return 0, nil, c.fail(wsCloseProtocol, fmt.Sprintf("Unknown opcode: %d", fop))
+		}
+		if len(msg)+len(p) > maxMessageSize {
+			return 0, nil, c.fail(wsCloseTooBig, "Message too big")
+		}
+		msg = append(msg, p...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, p []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0f
+	if hdr[1]&0x80 == 0 {
+		err = c.fail(wsCloseProtocol, "Frame not masked")
+		return
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var v uint16
+		err = binary.Read(c.r, binary.BigEndian, &v)
+		n = uint64(v)
+	case 127:
+		err = binary.Read(c.r, binary.BigEndian, &n)
+	}
+	if err != nil {
+		return
+	}
+	if n > maxMessageSize {
+		err = c.fail(wsCloseTooBig, "Message too big")
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	p = make([]byte, n)
+	if _, err = io.ReadFull(c.r, p); err != nil {
+		return
+	}
+	for i := range p {
+		p[i] ^= mask[i%4]
+	}
+	return
+}
+
+func (c *wsConn) fail(code uint16, reason string) error {
+	c.Close(code, reason)
+	return errors.New(reason)
+}
+
+// Close sends a close frame with the status and closes the connection.
+func (c *wsConn) Close(code uint16, reason string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	// a reason must fit in a control frame
+	if len(reason) > 123 {
+		reason = reason[:runeBoundary([]byte(reason[:123]))]
+	}
+	p := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(p, code)
+	c.writeFrame(wsClose, append(p, reason...))
+	return c.conn.Close()
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type WebSocketSuite struct{}
+
+var _ = Suite(&WebSocketSuite{})
+
+// clientFrame returns a masked frame as clients send.
+func clientFrame(fin bool, op byte, p []byte) []byte {
+	b := []byte{op, 0x80}
+	if fin {
+		b[0] |= 0x80
+	}
+	if len(p) < 126 {
+		b[1] |= byte(len(p))
+	} else {
+		b[1] |= 126
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(len(p)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i, c := range p {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+func readServerFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(hdr[1] & 0x7f)
+	if n == 126 {
+		var v uint16
+		binary.Read(r, binary.BigEndian, &v)
+		n = int(v)
+	}
+	p := make([]byte, n)
+	_, err := io.ReadFull(r, p)
+	return hdr[0] & 0x0f, p, err
+}
+
+func (s *WebSocketSuite) TestAccept(c *C) {
+	// the example in RFC 6455
+	c.Assert(wsAccept("dGhlIHNhbXBsZSBub25jZQ=="), Equals, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func (s *WebSocketSuite) TestUpgrade(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		_, p, err := ws.ReadMessage()
+		c.Assert(err, IsNil)
+		ws.WriteMessage(wsBinary, p)
+		ws.Close(wsCloseNormal, "")
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	c.Assert(req.Write(conn), IsNil)
+	br := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(br, req)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusSwitchingProtocols)
+	c.Assert(resp.Header.Get("Sec-WebSocket-Accept"), Equals, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+
+	conn.Write(clientFrame(true, wsText, []byte("echo")))
+	op, p, err := readServerFrame(br)
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, byte(wsBinary))
+	c.Assert(string(p), Equals, "echo")
+	op, p, err = readServerFrame(br)
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, byte(wsClose))
+	c.Assert(binary.BigEndian.Uint16(p), Equals, uint16(wsCloseNormal))
+}
+
+func (s *WebSocketSuite) TestReadMessage(c *C) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := newWSConn(server, nil)
+	go func() {
+		client.Write(clientFrame(false, wsBinary, []byte("hel")))
+		// control frames may come between fragments
+		client.Write(clientFrame(true, wsPing, []byte("p")))
+		client.Write(clientFrame(true, wsContinuation, []byte(strings.Repeat("l", 200))))
+		client.Write(clientFrame(true, wsClose, nil))
+	}()
+	pong := make(chan []byte, 1)
+	go func() {
+		op, p, _ := readServerFrame(client)
+		c.Check(op, Equals, byte(wsPong))
+		pong <- p
+		readServerFrame(client) // close
+	}()
+
+	op, p, err := ws.ReadMessage()
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, byte(wsBinary))
+	c.Assert(string(p), Equals, "hel"+strings.Repeat("l", 200))
+	c.Assert(string(<-pong), Equals, "p")
+	_, _, err = ws.ReadMessage()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *WebSocketSuite) TestUnmasked(c *C) {
+	client, server := net.Pipe()
+	defer client.Close()
+	ws := newWSConn(server, nil)
+	go func() {
+		client.Write([]byte{0x82, 1, 'a'})
+		op, p, _ := readServerFrame(client)
+		c.Check(op, Equals, byte(wsClose))
+		c.Check(binary.BigEndian.Uint16(p), Equals, uint16(wsCloseProtocol))
+	}()
+	_, _, err := ws.ReadMessage()
+	c.Assert(err, ErrorMatches, "Frame not masked")
+}
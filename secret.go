@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/rpc"
+	"github.com/yosisa/craft/secret"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type CmdSecret struct{}
+
+type CmdSecretSet struct {
+	Args struct {
+		Name  string `positional-arg-name:"NAME"`
+		Value string `positional-arg-name:"VALUE"`
+	} `positional-args:"yes"`
+}
+
+func (opts *CmdSecretSet) Execute(args []string) error {
+	if opts.Args.Name == "" {
+		log.Fatal("Secret name required")
+	}
+	key := loadSecretKey()
+	value := opts.Args.Value
+	if value == "" {
+		// read from stdin to keep it out of the shell history
+		var err error
+		if value, err = readSecretValue(); err != nil {
+			log.WithField("error", err).Fatal("Failed to read secret value")
+		}
+	}
+	b, err := secret.Encrypt(key, []byte(value))
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to encrypt secret")
+	}
+	logRPCError(rpc.SetSecret(gopts.agents(), opts.Args.Name, b))
+	return nil
+}
+
+type CmdSecretGet struct {
+	Args struct {
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (opts *CmdSecretGet) Execute(args []string) error {
+	key := loadSecretKey()
+	b, err := rpc.GetSecret(gopts.agents(), opts.Args.Name)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to get secret")
+	}
+	if b, err = secret.Decrypt(key, b); err != nil {
+		log.WithField("error", err).Fatal("Failed to decrypt secret")
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+type CmdSecretRm struct {
+	Args struct {
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (opts *CmdSecretRm) Execute(args []string) error {
+	logRPCError(rpc.RemoveSecret(gopts.agents(), opts.Args.Name))
+	return nil
+}
+
+type CmdSecretLs struct{}
+
+func (opts *CmdSecretLs) Execute(args []string) error {
+	resp, err := rpc.ListSecrets(gopts.agents())
+	logRPCError(err)
+
+	agents := make(map[string][]string)
+	for _, agent := range sortedKeys(resp) {
+		for _, name := range resp[agent].([]string) {
+			agents[name] = append(agents[name], agent)
+		}
+	}
+	var names []string
+	for name := range agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tw tableWriter
+	tw.Append("NAME", "AGENTS")
+	for _, name := range names {
+		tw.Append(name, strings.Join(agents[name], ","))
+	}
+	tw.Write(os.Stdout, "")
+	return nil
+}
+
+type CmdSecretKeygen struct{}
+
+func (opts *CmdSecretKeygen) Execute(args []string) error {
+	key, err := secret.GenerateKey()
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to generate secret key")
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func loadSecretKey() []byte {
+	if gopts.conf == nil {
+		gopts.ParseConfig()
+	}
+	key, err := secret.LoadKey(gopts.conf.SecretKey)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "path": gopts.conf.SecretKey}).Fatal("Failed to load secret key")
+	}
+	return key
+}
+
+func readSecretValue() (string, error) {
+	if terminal.IsTerminal(0) {
+		fmt.Fprint(os.Stderr, "Value: ")
+		b, err := terminal.ReadPassword(0)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	s, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if s = strings.TrimRight(s, "\r\n"); s != "" {
+		err = nil
+	}
+	return s, err
+}
+
+func init() {
+	cmd, err := parser.AddCommand("secret", "Manage secrets referenced from manifests as secret://NAME", "", &CmdSecret{})
+	if err != nil {
+		panic(err)
+	}
+	cmd.AddCommand("set", "Encrypt a value and store it on agents", "", &CmdSecretSet{})
+	cmd.AddCommand("get", "Show the decrypted value of a secret", "", &CmdSecretGet{})
+	cmd.AddCommand("rm", "Remove a secret from agents", "", &CmdSecretRm{})
+	cmd.AddCommand("ls", "List secrets and agents having them", "", &CmdSecretLs{})
+	cmd.AddCommand("keygen", "Generate a key to be shared by the client and agents", "", &CmdSecretKeygen{})
+}
@@ -0,0 +1,205 @@
+// Package secret encrypts values referenced from manifests as secret://name.
+// The client and agents share a key, so values are encrypted by the client
+// and decrypted by agents only when they start containers.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const Scheme = "secret://"
+
+const keySize = 32
+
+var ErrInvalidKey = errors.New("Secret key must be 32 bytes encoded in base64")
+
+// GenerateKey returns a new key encoded in base64.
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// LoadKey reads a key encoded in base64 from the file.
+func LoadKey(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Encrypt encrypts the plaintext using AES-GCM. The nonce is prepended to
+// the result.
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func Decrypt(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Secret value too short")
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	if err != nil {
+		return nil, errors.New("Could not decrypt secret, the key may differ")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Name returns the name of the secret if v references one.
+func Name(v string) (string, bool) {
+	if !strings.HasPrefix(v, Scheme) {
+		return "", false
+	}
+	return v[len(Scheme):], true
+}
+
+// Store keeps encrypted values in a file. Values are decrypted only when
+// resolved.
+type Store struct {
+	path   string
+	key    []byte
+	values map[string][]byte
+	m      sync.Mutex
+}
+
+func OpenStore(path string, key []byte) (*Store, error) {
+	if len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	s := &Store{path: path, key: key, values: make(map[string][]byte)}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &s.values); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set stores the encrypted value. It fails if the value can't be decrypted
+// by the key of the store.
+func (s *Store) Set(name string, ciphertext []byte) error {
+	if name == "" {
+		return errors.New("Secret name required")
+	}
+	if _, err := Decrypt(s.key, ciphertext); err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.values[name] = ciphertext
+	return s.save()
+}
+
+// Get returns the encrypted value.
+func (s *Store) Get(name string) ([]byte, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	v, ok := s.values[name]
+	return v, ok
+}
+
+func (s *Store) Remove(name string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.values[name]; !ok {
+		return fmt.Errorf("Unknown secret: %s", name)
+	}
+	delete(s.values, name)
+	return s.save()
+}
+
+// Names returns sorted names of secrets.
+func (s *Store) Names() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns a copy of env whose secret references are replaced with
+// the decrypted values.
+func (s *Store) Resolve(env map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(env))
+	for k, v := range env {
+		if name, ok := Name(v); ok {
+			ciphertext, ok := s.Get(name)
+			if !ok {
+				return nil, fmt.Errorf("Unknown secret: %s", name)
+			}
+			b, err := Decrypt(s.key, ciphertext)
+			if err != nil {
+				return nil, err
+			}
+			v = string(b)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+// save writes the values to a temporary file and renames it to be atomic.
+func (s *Store) save() error {
+	b, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,128 @@
+package secret
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	s, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, _ := GenerateKey()
+	path := filepath.Join(dir, "key")
+	ioutil.WriteFile(path, []byte(s+"\n"), 0600)
+	key, err := LoadKey(path)
+	if err != nil || len(key) != keySize {
+		t.Fatalf("unexpected key: %v %v", key, err)
+	}
+
+	ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0600)
+	if _, err = LoadKey(path); err != ErrInvalidKey {
+		t.Fatalf("expected ErrInvalidKey, but %v", err)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := testKey(t)
+	c1, err := Encrypt(key, []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := Encrypt(key, []byte("password"))
+	if reflect.DeepEqual(c1, c2) {
+		t.Fatal("ciphertext must differ by nonce")
+	}
+	if strings.Contains(string(c1), "password") {
+		t.Fatal("ciphertext contains plaintext")
+	}
+	b, err := Decrypt(key, c1)
+	if err != nil || string(b) != "password" {
+		t.Fatalf("unexpected plaintext: %q %v", b, err)
+	}
+	if _, err = Decrypt(testKey(t), c1); err == nil {
+		t.Fatal("decrypted by another key")
+	}
+	if _, err = Decrypt(key, c1[:4]); err == nil {
+		t.Fatal("decrypted short value")
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := testKey(t)
+	path := filepath.Join(dir, "store", "secrets.json")
+	s, err := OpenStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _ := Encrypt(key, []byte("pass"))
+	if err = s.Set("db", v); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := Encrypt(testKey(t), []byte("pass"))
+	if err = s.Set("api", other); err == nil {
+		t.Fatal("stored a value encrypted by another key")
+	}
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "pass") {
+		t.Fatal("store contains plaintext")
+	}
+
+	// reopen to load from the file
+	if s, err = OpenStore(path, key); err != nil {
+		t.Fatal(err)
+	}
+	if names := s.Names(); !reflect.DeepEqual(names, []string{"db"}) {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	env := map[string]string{"DB_PASSWORD": "secret://db", "PORT": "80"}
+	resolved, err := s.Resolve(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]string{"DB_PASSWORD": "pass", "PORT": "80"}; !reflect.DeepEqual(resolved, expected) {
+		t.Fatalf("expected %v, but %v", expected, resolved)
+	}
+	if env["DB_PASSWORD"] != "secret://db" {
+		t.Fatal("env must not be modified")
+	}
+	if _, err = s.Resolve(map[string]string{"X": "secret://unknown"}); err == nil {
+		t.Fatal("resolved an unknown secret")
+	}
+
+	if err = s.Remove("db"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Remove("db"); err == nil {
+		t.Fatal("removed an unknown secret")
+	}
+	if names := s.Names(); len(names) != 0 {
+		t.Fatalf("unexpected names: %v", names)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdServices struct {
+	FormatOptions
+	Args struct {
+		Name string `positional-arg-name:"NAME"`
+	} `positional-args:"yes"`
+}
+
+func (opts *CmdServices) Execute(args []string) error {
+	resp, err := rpc.Services(gopts.agents(), opts.Args.Name)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to get services")
+	}
+	if opts.Formatted() {
+		return opts.WriteRecords(os.Stdout, resp.Services)
+	}
+
+	var tw tableWriter
+	tw.Append("NAME", "AGENT", "EXPOSED", "ADDRESS")
+	for _, s := range resp.Services {
+		tw.Append(s.Name, s.Agent, s.Exposed, net.JoinHostPort(s.Addr, strconv.FormatInt(s.Port, 10)))
+	}
+	tw.Write(os.Stdout, "")
+	return nil
+}
+
+func init() {
+	parser.AddCommand("services", "List published ports of containers in the cluster", "", &CmdServices{})
+}
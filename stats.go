@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdStats struct {
+	FormatOptions
+	NoStream bool `long:"no-stream" description:"Disable streaming stats and print the first result (JSON by default)"`
+	Args     struct {
+		Containers []string `positional-arg-name:"CONTAINER"`
+	} `positional-args:"yes"`
+}
+
+type agentStats struct {
+	Agent string
+	*rpc.ContainerStats
+}
+
+func (opts *CmdStats) Execute(args []string) error {
+	if !opts.NoStream {
+		logRPCError(rpc.StreamStats(gopts.agents(), opts.Args.Containers))
+		return nil
+	}
+
+	stats, err := rpc.Stats(gopts.agents(), opts.Args.Containers)
+	logRPCError(err)
+	out := []agentStats{}
+	for _, agent := range sortedKeys(stats) {
+		for _, s := range stats[agent].([]*rpc.ContainerStats) {
+			out = append(out, agentStats{agent, s})
+		}
+	}
+	if !opts.Formatted() {
+		opts.Format = "json"
+	}
+	return opts.WriteRecords(os.Stdout, out)
+}
+
+func init() {
+	parser.AddCommand("stats", "Display resource usage statistics of containers", "", &CmdStats{})
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/yosisa/craft/docker"
 	"github.com/yosisa/craft/rpc"
@@ -19,15 +21,20 @@ func (opts *CmdSubmit) Execute(args []string) error {
 	if err != nil {
 		log.WithField("error", err).Fatal("Could not parse manifest")
 	}
+	expr, err := restrictExpr(m)
+	if err != nil {
+		printFilterError(err)
+		log.WithField("error", err).Fatal("Invalid restrict expression")
+	}
 	m.Name += opts.NameSuffix
 	m.Replace += opts.ReplaceSuffix
 
 	caps := gatherCapabilities(gopts.agents())
-	agent := findBestAgent(m, caps.Copy())
+	agent := findBestAgent(m, caps.Copy(), expr)
 	if agent == "" {
 		log.WithField("error", "No available agents").Fatal("Could not find best agent")
 	}
-	exlinks, err := resolveExLinks(m, caps)
+	exlinks, err := resolveExLinks(m, caps, agent)
 	if err != nil {
 		log.WithField("error", err).Fatal("Failed to resolve exlinks")
 	}
@@ -36,7 +43,15 @@ func (opts *CmdSubmit) Execute(args []string) error {
 	if err != nil {
 		log.WithField("error", err).Fatal("RPC failed")
 	}
-	log.WithFields(log.Fields{"name": m.Name, "agent": resp.Agent}).Info("Container running")
+	fields := log.Fields{"name": m.Name, "agent": resp.Agent}
+	var ports []string
+	for _, p := range resp.Ports {
+		ports = append(ports, p.String())
+	}
+	if len(ports) > 0 {
+		fields["ports"] = strings.Join(ports, ",")
+	}
+	log.WithFields(fields).Info("Container running")
 	return nil
 }
 
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yosisa/craft/rpc"
+)
+
+type CmdVersion struct{}
+
+func (opts *CmdVersion) Execute(args []string) error {
+	fmt.Printf("Client: %s (protocol %d)\n\n", rpc.Version, rpc.ProtocolVersion)
+	versions, err := rpc.Versions(gopts.agents())
+	logRPCError(err)
+
+	var tw tableWriter
+	tw.Append("AGENT", "VERSION", "PROTOCOL", "FEATURES")
+	for _, agent := range sortedKeys(versions) {
+		h := versions[agent].(*rpc.Hello)
+		tw.Append(agent, h.Version, strconv.Itoa(h.Protocol), strings.Join(h.Features, ","))
+	}
+	tw.Write(os.Stdout, "")
+	return nil
+}
+
+func init() {
+	parser.AddCommand("version", "Show versions of client and agents", "", &CmdVersion{})
+}